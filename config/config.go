@@ -10,11 +10,19 @@ import (
 
 // Config Config
 type Config struct {
-	APIVersion string   `json:"apiVersion,omitempty" yaml:"apiVersion,omitempty"`
-	Network    *Network `json:"network,omitempty" yaml:"network,omitempty"`
-	Policy     *Policy  `json:"policy,omitempty" yaml:"policy,omitempty"`
-	Logging    *Logging `json:"logging,omitempty" yaml:"logging,omitempty"`
-	Data       *Data    `json:"data,omitempty" yaml:"data,omitempty"`
+	APIVersion  string       `json:"apiVersion,omitempty" yaml:"apiVersion,omitempty"`
+	Network     *Network     `json:"network,omitempty" yaml:"network,omitempty"`
+	Policy      *Policy      `json:"policy,omitempty" yaml:"policy,omitempty"`
+	Logging     *Logging     `json:"logging,omitempty" yaml:"logging,omitempty"`
+	Kadmin      *Kadmin      `json:"kadmin,omitempty" yaml:"kadmin,omitempty"`
+	Rotation    *Rotation    `json:"rotation,omitempty" yaml:"rotation,omitempty"`
+	Events      *Events      `json:"events,omitempty" yaml:"events,omitempty"`
+	Peers       *Peers       `json:"peers,omitempty" yaml:"peers,omitempty"`
+	Certificate *Certificate `json:"certificate,omitempty" yaml:"certificate,omitempty"`
+	SSH         *SSH         `json:"ssh,omitempty" yaml:"ssh,omitempty"`
+	MasterKey   *MasterKey   `json:"masterKey,omitempty" yaml:"masterKey,omitempty"`
+	Store       *Store       `json:"store,omitempty" yaml:"store,omitempty"`
+	Data        *Data        `json:"data,omitempty" yaml:"data,omitempty"`
 }
 
 // Network Config
@@ -26,10 +34,23 @@ type Network struct {
 	TLSKey    string `json:"tlsKey,omitempty" yaml:"tlsKey,omitempty"`
 }
 
-// Policy Config
+// Policy Config. NonceMode is local (default) where nonces are only known to
+// the instance that issued them or signed where nonces are signed with a key
+// derived from the master key so that any instance may validate them. If
+// NonceSingleUse is true a nonce is consumed by the first request it grants
+// and later requests with it are rejected as a replay. Consumed nonces are
+// only known to the instance that consumed them so NonceSingleUse is not
+// supported with the signed NonceMode. NonceBinding is the
+// token claims a nonce is bound to. A nonce is only accepted with a token that
+// has the same values for these claims as the token it was requested with.
+// Default is none. With the signed NonceMode the values of the claims are
+// readable by anyone holding the nonce.
 type Policy struct {
 	Policy         string        `json:"policy,omitempty" yaml:"policy,omitempty"`
 	NonceLifetime  time.Duration `json:"nonceLifetime,omitempty" yaml:"nonceLifetime,omitempty"`
+	NonceMode      string        `json:"nonceMode,omitempty" yaml:"nonceMode,omitempty"`
+	NonceSingleUse bool          `json:"nonceSingleUse,omitempty" yaml:"nonceSingleUse,omitempty"`
+	NonceBinding   []string      `json:"nonceBinding,omitempty" yaml:"nonceBinding,omitempty"`
 	KeytabLifetime time.Duration `json:"keytabLifetime,omitempty" yaml:"keytabLifetime,omitempty"`
 }
 
@@ -41,24 +62,159 @@ type Logging struct {
 	ErrorOutputPaths []string `json:"errorOutputPaths,omitempty" yaml:"errorOutputPaths,omitempty"`
 }
 
+// Kadmin Config for the kadmin keytab backend. If Keytab is empty then
+// kadmin.local is used on the KDC host
+type Kadmin struct {
+	Exe       string `json:"exe,omitempty" yaml:"exe,omitempty"`
+	Principal string `json:"principal,omitempty" yaml:"principal,omitempty"`
+	Keytab    string `json:"keytab,omitempty" yaml:"keytab,omitempty"`
+}
+
+// Rotation Config for keytab rotation. Workers is the maximum number of
+// keytabs generated at the same time and Jitter the maximum random time
+// before the end of the period that the next keytab is generated
+type Rotation struct {
+	Workers int           `json:"workers,omitempty" yaml:"workers,omitempty"`
+	Jitter  time.Duration `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+}
+
+// Events Config. Events are sent when a keytab or secret is rotated, fails or
+// expires at the end of its period. Each Exec command is ran with the event as
+// JSON on stdin, each Webhook is posted the event and each File has the event
+// appended
+type Events struct {
+	Exec     []*Exec    `json:"exec,omitempty" yaml:"exec,omitempty"`
+	Webhooks []*Webhook `json:"webhooks,omitempty" yaml:"webhooks,omitempty"`
+	Files    []*File    `json:"files,omitempty" yaml:"files,omitempty"`
+}
+
+// Exec Config
+type Exec struct {
+	Command string        `json:"command,omitempty" yaml:"command,omitempty"`
+	Args    []string      `json:"args,omitempty" yaml:"args,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// Webhook Config
+type Webhook struct {
+	URL     string            `json:"url,omitempty" yaml:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Timeout time.Duration     `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// File Config
+type File struct {
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// Peers Config. Peers are the base URLs of the other instances of the server.
+// The password fingerprints of each keytab and secret are compared with each
+// peer every Interval. Token is shared by the instances and is required to
+// get the fingerprints and the health of each keytab and peer from /health.
+// The fingerprints are keyed with a key derived from the Token. Peers must be
+// https URLs unless AllowHTTP is true.
+type Peers struct {
+	Peers     []string      `json:"peers,omitempty" yaml:"peers,omitempty"`
+	Token     string        `json:"token,omitempty" yaml:"token,omitempty"`
+	Interval  time.Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	AllowHTTP bool          `json:"allowHTTP,omitempty" yaml:"allowHTTP,omitempty"`
+}
+
+// Certificate Config. CACert and CAKey are the PEM encoded certificate and
+// private key of the CA that signs issued certificates. Lifetime is the
+// lifetime of a certificate when the request does not specify one and
+// MaxLifetime the upper bound regardless of the request or policy
+type Certificate struct {
+	CACert      string        `json:"caCert,omitempty" yaml:"caCert,omitempty"`
+	CAKey       string        `json:"caKey,omitempty" yaml:"caKey,omitempty"`
+	Lifetime    time.Duration `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
+	MaxLifetime time.Duration `json:"maxLifetime,omitempty" yaml:"maxLifetime,omitempty"`
+}
+
+// SSH Config. CAKey is the private key of the SSH CA in OpenSSH or PEM format
+// that signs issued user and host certificates. Lifetime is the lifetime of a
+// certificate when the request does not specify one and MaxLifetime the upper
+// bound regardless of the request or policy
+type SSH struct {
+	CAKey       string        `json:"caKey,omitempty" yaml:"caKey,omitempty"`
+	Lifetime    time.Duration `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
+	MaxLifetime time.Duration `json:"maxLifetime,omitempty" yaml:"maxLifetime,omitempty"`
+}
+
+// MasterKey Config. KeyFile is the path of the file with the master key. If
+// it is not set the master key is read from the environment variable
+// TOKENS2SECRETS_MASTER_KEY. The master key encrypts the store and seeds
+// sealed with the config seal command
+type MasterKey struct {
+	KeyFile string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+}
+
+// Store Config. Path is the file stored secrets are kept in encrypted with
+// the master key. Stored secrets are served by name in the same way as
+// derived secrets
+type Store struct {
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
 // Data Config
 type Data struct {
 	Keytabs []*Keytab `json:"keytabs,omitempty" yaml:"keytabs,omitempty"`
 	Secrets []*Secret `json:"secrets,omitempty" yaml:"secrets,omitempty"`
 }
 
-// Secret Config
+// Secret Config. See secret.Secret
 type Secret struct {
-	Name     string        `json:"name,omitempty" yaml:"name,omitempty"`
-	Seed     string        `json:"seed,omitempty" yaml:"seed,omitempty"`
-	Lifetime time.Duration `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
+	Name       string         `json:"name,omitempty" yaml:"name,omitempty"`
+	Seed       string         `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Lifetime   time.Duration  `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
+	Format     string         `json:"format,omitempty" yaml:"format,omitempty"`
+	Length     int            `json:"length,omitempty" yaml:"length,omitempty"`
+	Charset    string         `json:"charset,omitempty" yaml:"charset,omitempty"`
+	Require    []string       `json:"require,omitempty" yaml:"require,omitempty"`
+	Separator  string         `json:"separator,omitempty" yaml:"separator,omitempty"`
+	Derivation string         `json:"derivation,omitempty" yaml:"derivation,omitempty"`
+	Seeds      []*SeedVersion `json:"seeds,omitempty" yaml:"seeds,omitempty"`
+	Schedule   string         `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	Offset     time.Duration  `json:"offset,omitempty" yaml:"offset,omitempty"`
+	Stagger    bool           `json:"stagger,omitempty" yaml:"stagger,omitempty"`
 }
 
-// Keytab Config
+// Keytab Config. See keytab.Keytab
+//
+// RotateKvno: Increment the kvno each rotation starting from Kvno
+//
+// PrincipalType: KRB5_NT_PRINCIPAL (default), KRB5_NT_SRV_INST or
+// KRB5_NT_SRV_HST
+//
+// SPNs: Templates for the service principal names. See keytab.expandSPNs
+//
+// Principal: May contain the wildcards * and ? (for example
+// svc-*@EXAMPLE.COM). Matching principals are provisioned on request and
+// evicted after being Idle
 type Keytab struct {
-	Principal string        `json:"principal,omitempty" yaml:"name,omitempty"`
-	Seed      string        `json:"seed,omitempty" yaml:"seed,omitempty"`
-	Lifetime  time.Duration `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
+	Principal     string         `json:"principal,omitempty" yaml:"name,omitempty"`
+	Seed          string         `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Lifetime      time.Duration  `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
+	Backend       string         `json:"backend,omitempty" yaml:"backend,omitempty"`
+	Enctypes      []string       `json:"enctypes,omitempty" yaml:"enctypes,omitempty"`
+	Kvno          int            `json:"kvno,omitempty" yaml:"kvno,omitempty"`
+	RotateKvno    bool           `json:"rotateKvno,omitempty" yaml:"rotateKvno,omitempty"`
+	PrincipalType string         `json:"principalType,omitempty" yaml:"principalType,omitempty"`
+	Service       string         `json:"service,omitempty" yaml:"service,omitempty"`
+	Host          string         `json:"host,omitempty" yaml:"host,omitempty"`
+	SPNs          []string       `json:"spns,omitempty" yaml:"spns,omitempty"`
+	Idle          time.Duration  `json:"idle,omitempty" yaml:"idle,omitempty"`
+	Derivation    string         `json:"derivation,omitempty" yaml:"derivation,omitempty"`
+	Seeds         []*SeedVersion `json:"seeds,omitempty" yaml:"seeds,omitempty"`
+	Schedule      string         `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	Offset        time.Duration  `json:"offset,omitempty" yaml:"offset,omitempty"`
+	Stagger       bool           `json:"stagger,omitempty" yaml:"stagger,omitempty"`
+}
+
+// SeedVersion Config. See seed.Version
+type SeedVersion struct {
+	Seed       string    `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Activation time.Time `json:"activation,omitempty" yaml:"activation,omitempty"`
 }
 
 // NewConfig Returns new V1 Config
@@ -140,6 +296,18 @@ func (t *Config) Merge(config *Config) {
 			t.Policy.NonceLifetime = config.Policy.NonceLifetime
 		}
 
+		if config.Policy.NonceMode != "" {
+			t.Policy.NonceMode = config.Policy.NonceMode
+		}
+
+		if config.Policy.NonceSingleUse {
+			t.Policy.NonceSingleUse = true
+		}
+
+		if config.Policy.NonceBinding != nil {
+			t.Policy.NonceBinding = config.Policy.NonceBinding
+		}
+
 		if config.Policy.KeytabLifetime > 0 {
 			t.Policy.KeytabLifetime = config.Policy.KeytabLifetime
 		}
@@ -178,6 +346,164 @@ func (t *Config) Merge(config *Config) {
 
 	}
 
+	if config.Kadmin != nil {
+
+		if t.Kadmin == nil {
+			t.Kadmin = &Kadmin{}
+		}
+
+		if config.Kadmin.Exe != "" {
+			t.Kadmin.Exe = config.Kadmin.Exe
+		}
+
+		if config.Kadmin.Principal != "" {
+			t.Kadmin.Principal = config.Kadmin.Principal
+		}
+
+		if config.Kadmin.Keytab != "" {
+			t.Kadmin.Keytab = config.Kadmin.Keytab
+		}
+
+	}
+
+	if config.Rotation != nil {
+
+		if t.Rotation == nil {
+			t.Rotation = &Rotation{}
+		}
+
+		if config.Rotation.Workers > 0 {
+			t.Rotation.Workers = config.Rotation.Workers
+		}
+
+		if config.Rotation.Jitter > 0 {
+			t.Rotation.Jitter = config.Rotation.Jitter
+		}
+
+	}
+
+	if config.Events != nil {
+
+		if t.Events == nil {
+			t.Events = &Events{}
+		}
+
+		if config.Events.Exec != nil {
+			for _, s := range config.Events.Exec {
+				t.Events.Exec = append(t.Events.Exec, s)
+			}
+		}
+
+		if config.Events.Webhooks != nil {
+			for _, s := range config.Events.Webhooks {
+				t.Events.Webhooks = append(t.Events.Webhooks, s)
+			}
+		}
+
+		if config.Events.Files != nil {
+			for _, s := range config.Events.Files {
+				t.Events.Files = append(t.Events.Files, s)
+			}
+		}
+
+	}
+
+	if config.Peers != nil {
+
+		if t.Peers == nil {
+			t.Peers = &Peers{}
+		}
+
+		if config.Peers.Peers != nil {
+			for _, s := range config.Peers.Peers {
+				if s != "" {
+					t.Peers.Peers = append(t.Peers.Peers, s)
+				}
+			}
+		}
+
+		if config.Peers.Token != "" {
+			t.Peers.Token = config.Peers.Token
+		}
+
+		if config.Peers.Interval > 0 {
+			t.Peers.Interval = config.Peers.Interval
+		}
+
+		if config.Peers.AllowHTTP {
+			t.Peers.AllowHTTP = true
+		}
+
+	}
+
+	if config.Certificate != nil {
+
+		if t.Certificate == nil {
+			t.Certificate = &Certificate{}
+		}
+
+		if config.Certificate.CACert != "" {
+			t.Certificate.CACert = config.Certificate.CACert
+		}
+
+		if config.Certificate.CAKey != "" {
+			t.Certificate.CAKey = config.Certificate.CAKey
+		}
+
+		if config.Certificate.Lifetime > 0 {
+			t.Certificate.Lifetime = config.Certificate.Lifetime
+		}
+
+		if config.Certificate.MaxLifetime > 0 {
+			t.Certificate.MaxLifetime = config.Certificate.MaxLifetime
+		}
+
+	}
+
+	if config.SSH != nil {
+
+		if t.SSH == nil {
+			t.SSH = &SSH{}
+		}
+
+		if config.SSH.CAKey != "" {
+			t.SSH.CAKey = config.SSH.CAKey
+		}
+
+		if config.SSH.Lifetime > 0 {
+			t.SSH.Lifetime = config.SSH.Lifetime
+		}
+
+		if config.SSH.MaxLifetime > 0 {
+			t.SSH.MaxLifetime = config.SSH.MaxLifetime
+		}
+
+	}
+
+	if config.MasterKey != nil {
+
+		if t.MasterKey == nil {
+			t.MasterKey = &MasterKey{}
+		}
+
+		if config.MasterKey.KeyFile != "" {
+			t.MasterKey.KeyFile = config.MasterKey.KeyFile
+		}
+
+	}
+
+	if config.Store != nil {
+
+		if t.Store == nil {
+			t.Store = &Store{}
+		}
+
+		if config.Store.Path != "" {
+			t.Store.Path = config.Store.Path
+		}
+
+	}
+
 	if config.Data != nil {
 
 		if t.Data == nil {
@@ -13,6 +13,8 @@ package main
 default auth_get_nonce = false
 default auth_get_keytab = false
 default auth_get_secret = false
+default auth_get_certificate = false
+default auth_get_ssh_certificate = false
 
 auth_base {
    # Match Issuer
@@ -44,6 +46,23 @@ auth_get_secret {
    auth_base
    auth_nonce
 }
+
+auth_get_certificate = {"dns_names": names, "max_lifetime": 3600} {
+   # Certificates may only be issued for the DNS names in the comma separated
+   # claim service.certificate and for at most one hour
+   auth_base
+   auth_nonce
+   names := split(input.claims.service.certificate,",")
+}
+
+auth_get_ssh_certificate = {"principals": principals, "max_lifetime": 28800} {
+   # User certificates may only be issued for the logins in the comma separated
+   # claim service.ssh and for at most eight hours
+   auth_base
+   auth_nonce
+   input.ssh.type == "user"
+   principals := split(input.claims.service.ssh,",")
+}
 `
 
 var exampleTLSCert = `-----BEGIN CERTIFICATE-----
@@ -90,6 +109,10 @@ func NewV1ExampleConfig() *Config {
 			OutputPaths:      []string{"stderr"},
 			ErrorOutputPaths: []string{"stderr"},
 		},
+		Rotation: &Rotation{
+			Workers: 8,
+			Jitter:  time.Duration(5) * time.Second,
+		},
 		Data: &Data{
 			Keytabs: []*Keytab{
 				&Keytab{
@@ -98,9 +121,19 @@ func NewV1ExampleConfig() *Config {
 					Lifetime:  time.Duration(1) * time.Minute,
 				},
 				&Keytab{
-					Principal: "birdman@EXAMPLE.COM",
-					Seed:      "CibIcE3XhRyXrngddsQzN",
-					Lifetime:  time.Duration(2) * time.Minute,
+					Principal:  "birdman@EXAMPLE.COM",
+					Seed:       "CibIcE3XhRyXrngddsQzN",
+					Lifetime:   time.Duration(2) * time.Minute,
+					Enctypes:   []string{"aes256-cts-hmac-sha1-96", "aes128-cts-hmac-sha1-96"},
+					RotateKvno: true,
+					Host:       "birdman.example.com",
+					SPNs:       []string{"HTTP/{host}@{REALM}", "host/{host}@{REALM}"},
+				},
+				&Keytab{
+					Principal: "svc-*@EXAMPLE.COM",
+					Seed:      "Wq3mD8pXbT2vLr6sNfYhK",
+					Lifetime:  time.Duration(1) * time.Hour,
+					Idle:      time.Duration(24) * time.Hour,
 				},
 			},
 
@@ -120,6 +153,20 @@ func NewV1ExampleConfig() *Config {
 					Seed:     "6zarcky7proZTYw8PEVzzT",
 					Lifetime: time.Duration(10) * time.Minute,
 				},
+				&Secret{
+					Name:     "secret4",
+					Seed:     "q8VbN2xKt0fWm5RzLc7HsJ",
+					Lifetime: time.Duration(10) * time.Minute,
+					Format:   "charset",
+					Length:   32,
+					Require:  []string{"lower", "upper", "digit", "symbol"},
+				},
+				&Secret{
+					Name:     "secret5",
+					Seed:     "Xe4TgP9wYs1uDk6NbQa3Vm",
+					Lifetime: time.Duration(10) * time.Minute,
+					Format:   "passphrase",
+				},
 			},
 		},
 	}
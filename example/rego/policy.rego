@@ -13,7 +13,11 @@ package kbridge
 #     }
 #   },
 #   "principal": "user1@example.com",
-#   "nonce": "daisy"
+#   "nonce": "daisy",
+#   "binding": {
+#     "iss": "https://api.console.aporeto.com/v/1/namespaces/5ddc396b9facec0001d3c886/oauthinfo",
+#     "sub": "donut"
+#   }
 # }
 
 default auth_get_nonce = false
@@ -21,22 +21,45 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/jodydadescott/tokens2secrets/internal/certificate"
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
+	"github.com/jodydadescott/tokens2secrets/internal/event"
 	"github.com/jodydadescott/tokens2secrets/internal/keytab"
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
 	"github.com/jodydadescott/tokens2secrets/internal/nonce"
+	"github.com/jodydadescott/tokens2secrets/internal/peer"
 	"github.com/jodydadescott/tokens2secrets/internal/policy"
 	"github.com/jodydadescott/tokens2secrets/internal/publickey"
 	"github.com/jodydadescott/tokens2secrets/internal/secret"
+	"github.com/jodydadescott/tokens2secrets/internal/sshcert"
+	"github.com/jodydadescott/tokens2secrets/internal/store"
 	"github.com/jodydadescott/tokens2secrets/internal/token"
 	"go.uber.org/zap"
 )
 
-// Config ...
+// Config Config. NonceBinding is the claims of the token a nonce is requested
+// with that the nonce is bound to. The token a nonce is later presented with
+// must have the same values for these claims. If empty (default) nonces are
+// not bound.
 type Config struct {
 	Policy         string
 	NonceLifetime  time.Duration
 	SecretSecrets  []*secret.Secret
 	KeytabKeytabs  []*keytab.Keytab
 	KeytabLifetime time.Duration
+	KeytabKadmin   *keytab.Kadmin
+	KeytabWorkers  int
+	KeytabJitter   time.Duration
+	Events         *event.Config
+	Peers          *peer.Config
+	Certificate    *certificate.Config
+	SSH            *sshcert.Config
+	Store          *store.Config
+	MasterKey      *masterkey.Key
+	Clock          clock.Clock
+	NonceKey       []byte
+	NonceSingleUse bool
+	NonceBinding   []string
 }
 
 // Cache ...
@@ -47,6 +70,11 @@ type Cache struct {
 	secret    *secret.Cache
 	publickey publickey.Cache
 	policy    *policy.Policy
+	events    *event.Publisher
+	peer      *peer.Verifier
+	issuer    *certificate.Issuer
+	sshIssuer *sshcert.Issuer
+	binding   []string
 }
 
 // Build Returns a new Server
@@ -69,6 +97,12 @@ func (config *Config) Build() (*Cache, error) {
 		nonceConfig.Lifetime = config.NonceLifetime
 	}
 
+	if len(config.NonceKey) > 0 {
+		nonceConfig.Key = config.NonceKey
+	}
+
+	nonceConfig.SingleUse = config.NonceSingleUse
+
 	if config.SecretSecrets != nil {
 		secretConfig.Secrets = config.SecretSecrets
 	}
@@ -77,6 +111,33 @@ func (config *Config) Build() (*Cache, error) {
 		keytabConfig.Keytabs = config.KeytabKeytabs
 	}
 
+	if config.KeytabWorkers > 0 {
+		keytabConfig.Workers = config.KeytabWorkers
+	}
+
+	if config.KeytabJitter > 0 {
+		keytabConfig.Jitter = config.KeytabJitter
+	}
+
+	if config.MasterKey != nil {
+		keytabConfig.MasterKey = config.MasterKey
+		secretConfig.MasterKey = config.MasterKey
+	}
+
+	if config.Clock != nil {
+		publickeyConfig.Clock = config.Clock
+		tokenConfig.Clock = config.Clock
+		keytabConfig.Clock = config.Clock
+		nonceConfig.Clock = config.Clock
+		secretConfig.Clock = config.Clock
+	}
+
+	if config.KeytabKadmin != nil {
+		keytabConfig.Generators = map[string]keytab.Generator{
+			keytab.BackendKadmin: config.KeytabKadmin,
+		}
+	}
+
 	policy, err := policyConfig.Build()
 	if err != nil {
 		return nil, err
@@ -92,6 +153,24 @@ func (config *Config) Build() (*Cache, error) {
 		return nil, err
 	}
 
+	var events *event.Publisher
+	if config.Events != nil {
+		events, err = config.Events.Build()
+		if err != nil {
+			return nil, err
+		}
+		keytabConfig.Events = events
+		secretConfig.Events = events
+	}
+
+	if config.Store != nil {
+		store, err := config.Store.Build()
+		if err != nil {
+			return nil, err
+		}
+		secretConfig.Store = store
+	}
+
 	keytab, err := keytabConfig.Build()
 	if err != nil {
 		return nil, err
@@ -107,6 +186,37 @@ func (config *Config) Build() (*Cache, error) {
 		return nil, err
 	}
 
+	peerConfig := &peer.Config{}
+	if config.Peers != nil {
+		peerConfig.Peers = config.Peers.Peers
+		peerConfig.Token = config.Peers.Token
+		peerConfig.Interval = config.Peers.Interval
+		peerConfig.AllowHTTP = config.Peers.AllowHTTP
+	}
+	peerConfig.Sources = []peer.Source{keytab, secret}
+	peerConfig.Events = events
+
+	peer, err := peerConfig.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	var issuer *certificate.Issuer
+	if config.Certificate != nil {
+		issuer, err = config.Certificate.Build()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sshIssuer *sshcert.Issuer
+	if config.SSH != nil {
+		sshIssuer, err = config.SSH.Build()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Cache{
 		token:     token,
 		keytab:    keytab,
@@ -114,6 +224,11 @@ func (config *Config) Build() (*Cache, error) {
 		secret:    secret,
 		publickey: publickey,
 		policy:    policy,
+		events:    events,
+		peer:      peer,
+		issuer:    issuer,
+		sshIssuer: sshIssuer,
+		binding:   config.NonceBinding,
 	}, nil
 
 }
@@ -121,6 +236,10 @@ func (config *Config) Build() (*Cache, error) {
 // Shutdown shutdown
 func (t *Cache) Shutdown() {
 
+	if t.peer != nil {
+		t.peer.Shutdown()
+	}
+
 	if t.secret != nil {
 		t.secret.Shutdown()
 	}
@@ -141,6 +260,10 @@ func (t *Cache) Shutdown() {
 		t.publickey.Shutdown()
 	}
 
+	if t.events != nil {
+		t.events.Shutdown()
+	}
+
 }
 
 // GetNonce returns Nonce if provided token is authorized
@@ -159,7 +282,7 @@ func (t *Cache) GetNonce(ctx context.Context, tokenString string) (*nonce.Nonce,
 		return nil, err
 	}
 
-	nonce, err := t.nonce.NewNonce()
+	nonce, err := t.nonce.NewBoundNonce(t.getBinding(token))
 	if err != nil {
 		zap.L().Debug(fmt.Sprintf("GetNonce(tokenString=%s)->%s", tokenString, "Error:"+err.Error()))
 		return nil, err
@@ -178,13 +301,13 @@ func (t *Cache) GetKeytab(ctx context.Context, tokenString, principal string) (*
 		return nil, err
 	}
 
-	nonce, err := t.nonce.GetNonce(token.Aud)
+	nonce, err := t.getNonce(token, "GetKeytab")
 	if err != nil {
 		zap.L().Debug(fmt.Sprintf("GetKeytab(tokenString=%s,principal=%s)->%s", tokenString, principal, "Error:"+err.Error()))
 		return nil, err
 	}
 
-	err = t.policy.AuthGetKeytab(ctx, token.Claims, nonce.Value, principal)
+	err = t.policy.AuthGetKeytab(ctx, token.Claims, nonce.Value, nonce.Binding, principal)
 	if err != nil {
 		zap.L().Debug(fmt.Sprintf("GetKeytab(tokenString=%s,principal=%s)->%s", tokenString, principal, "Error:"+err.Error()))
 		return nil, err
@@ -197,10 +320,111 @@ func (t *Cache) GetKeytab(ctx context.Context, tokenString, principal string) (*
 		return nil, err
 	}
 
+	err = t.consumeNonce(token, nonce, "GetKeytab")
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetKeytab(tokenString=%s,principal=%s)->%s", tokenString, principal, "Error:"+err.Error()))
+		return nil, err
+	}
+
 	zap.L().Debug(fmt.Sprintf("GetKeytab(tokenString=%s,principal=%s)->%s", tokenString, principal, "Granted"))
 	return keytab, nil
 }
 
+// GetKeytabs returns the Keytab for each principal the provided token is
+// authorized for. The token and nonce are validated once for all principals.
+// Each principal is then authorized separately and if authorization or the
+// retrieval of the keytab fails the error is returned for that principal.
+func (t *Cache) GetKeytabs(ctx context.Context, tokenString string, principals []string) (*keytab.Batch, error) {
+
+	token, err := t.token.ParseToken(tokenString)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetKeytabs(tokenString=%s,principals=%s)->%s", tokenString, principals, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	nonce, err := t.getNonce(token, "GetKeytabs")
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetKeytabs(tokenString=%s,principals=%s)->%s", tokenString, principals, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	result := &keytab.Batch{
+		Keytabs: make(map[string]*keytab.Keytab),
+		Errors:  make(map[string]string),
+	}
+
+	for _, principal := range principals {
+
+		err = t.policy.AuthGetKeytab(ctx, token.Claims, nonce.Value, nonce.Binding, principal)
+		if err != nil {
+			zap.L().Debug(fmt.Sprintf("GetKeytabs(tokenString=%s,principal=%s)->%s", tokenString, principal, "Error:"+err.Error()))
+			result.Errors[principal] = err.Error()
+			continue
+		}
+
+		keytab, err := t.keytab.GetKeytab(principal)
+		if err != nil {
+			zap.L().Debug(fmt.Sprintf("GetKeytabs(tokenString=%s,principal=%s)->%s", tokenString, principal, "Error:"+err.Error()))
+			result.Errors[principal] = err.Error()
+			continue
+		}
+
+		zap.L().Debug(fmt.Sprintf("GetKeytabs(tokenString=%s,principal=%s)->%s", tokenString, principal, "Granted"))
+		result.Keytabs[principal] = keytab
+	}
+
+	// The nonce is consumed once for the batch if any keytab was granted
+	if len(result.Keytabs) > 0 {
+		err = t.consumeNonce(token, nonce, "GetKeytabs")
+		if err != nil {
+			zap.L().Debug(fmt.Sprintf("GetKeytabs(tokenString=%s,principals=%s)->%s", tokenString, principals, "Error:"+err.Error()))
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// GetKeytabHealth returns the health of each keytab. The health contains the
+// principals and backend errors so it should only be served in detail to
+// callers that have passed AuthHealth.
+func (t *Cache) GetKeytabHealth(ctx context.Context) []*keytab.Health {
+	return t.keytab.GetHealth()
+}
+
+// AuthHealth returns nil if the provided peer token is authorized to get the
+// detailed health
+func (t *Cache) AuthHealth(ctx context.Context, peerToken string) error {
+
+	err := t.peer.Auth(peerToken)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("AuthHealth()->%s", "Error:"+err.Error()))
+		return err
+	}
+
+	zap.L().Debug(fmt.Sprintf("AuthHealth()->%s", "Granted"))
+	return nil
+}
+
+// GetPeerHealth returns the result of the last check of each peer
+func (t *Cache) GetPeerHealth(ctx context.Context) []*peer.Health {
+	return t.peer.GetHealth()
+}
+
+// GetFingerprints returns the fingerprints of the keytabs and secrets if the
+// provided peer token matches
+func (t *Cache) GetFingerprints(ctx context.Context, peerToken string) (*peer.Fingerprints, error) {
+
+	result, err := t.peer.GetFingerprints(peerToken)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetFingerprints()->%s", "Error:"+err.Error()))
+		return nil, err
+	}
+
+	zap.L().Debug(fmt.Sprintf("GetFingerprints()->%s", "Granted"))
+	return result, nil
+}
+
 // GetSecret returns Secret if provided token is authorized
 func (t *Cache) GetSecret(ctx context.Context, tokenString, name string) (*secret.Secret, error) {
 
@@ -210,13 +434,13 @@ func (t *Cache) GetSecret(ctx context.Context, tokenString, name string) (*secre
 		return nil, err
 	}
 
-	nonce, err := t.nonce.GetNonce(token.Aud)
+	nonce, err := t.getNonce(token, "GetSecret")
 	if err != nil {
 		zap.L().Debug(fmt.Sprintf("GetSecret(tokenString=%s,principal=%s)->%s", tokenString, name, "Error:"+err.Error()))
 		return nil, err
 	}
 
-	err = t.policy.AuthGetSecret(ctx, token.Claims, nonce.Value, name)
+	err = t.policy.AuthGetSecret(ctx, token.Claims, nonce.Value, nonce.Binding, name)
 	if err != nil {
 		zap.L().Debug(fmt.Sprintf("GetSecret(tokenString=%s,name=%s)->%s", tokenString, name, "Error:"+err.Error()))
 		return nil, err
@@ -228,6 +452,162 @@ func (t *Cache) GetSecret(ctx context.Context, tokenString, name string) (*secre
 		return nil, err
 	}
 
+	err = t.consumeNonce(token, nonce, "GetSecret")
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetSecret(tokenString=%s,name=%s)->%s", tokenString, name, "Error:"+err.Error()))
+		return nil, err
+	}
+
 	zap.L().Debug(fmt.Sprintf("GetSecret(tokenString=%s,name=%s)->%s", tokenString, name, "Granted"))
 	return secret, nil
 }
+
+// GetCertificate returns a certificate for the CSR if provided token is
+// authorized. The names in the CSR are provided to the policy and the
+// certificate is constrained by the result of the policy.
+func (t *Cache) GetCertificate(ctx context.Context, tokenString, csrString string, lifetime time.Duration) (*certificate.Certificate, error) {
+
+	if t.issuer == nil {
+		return nil, certificate.ErrNotConfigured
+	}
+
+	token, err := t.token.ParseToken(tokenString)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetCertificate(tokenString=%s)->%s", tokenString, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	nonce, err := t.getNonce(token, "GetCertificate")
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetCertificate(tokenString=%s)->%s", tokenString, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	csr, request, err := certificate.ParseCSR(csrString)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetCertificate(tokenString=%s)->%s", tokenString, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	constraints, err := t.policy.AuthGetCertificate(ctx, token.Claims, nonce.Value, nonce.Binding, request)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetCertificate(tokenString=%s,cn=%s)->%s", tokenString, request.CommonName, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	cert, err := t.issuer.Issue(csr, constraints, lifetime)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetCertificate(tokenString=%s,cn=%s)->%s", tokenString, request.CommonName, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	err = t.consumeNonce(token, nonce, "GetCertificate")
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetCertificate(tokenString=%s,cn=%s)->%s", tokenString, request.CommonName, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	zap.L().Debug(fmt.Sprintf("GetCertificate(tokenString=%s,cn=%s)->%s", tokenString, request.CommonName, "Granted"))
+	return cert, nil
+}
+
+// GetSSHCertificate returns an SSH certificate of certType (user or host) for
+// the public key if provided token is authorized. The requested principals
+// are provided to the policy and the certificate is constrained by the result
+// of the policy.
+func (t *Cache) GetSSHCertificate(ctx context.Context, tokenString, publicKeyString, certType string, principals []string, lifetime time.Duration) (*sshcert.Certificate, error) {
+
+	if t.sshIssuer == nil {
+		return nil, sshcert.ErrNotConfigured
+	}
+
+	token, err := t.token.ParseToken(tokenString)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetSSHCertificate(tokenString=%s)->%s", tokenString, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	nonce, err := t.getNonce(token, "GetSSHCertificate")
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetSSHCertificate(tokenString=%s)->%s", tokenString, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	publicKey, request, err := sshcert.ParsePublicKey(publicKeyString, certType, principals)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetSSHCertificate(tokenString=%s)->%s", tokenString, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	constraints, err := t.policy.AuthGetSSHCertificate(ctx, token.Claims, nonce.Value, nonce.Binding, request)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetSSHCertificate(tokenString=%s,principals=%s)->%s", tokenString, principals, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	cert, err := t.sshIssuer.Issue(publicKey, request, constraints, lifetime)
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetSSHCertificate(tokenString=%s,principals=%s)->%s", tokenString, principals, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	err = t.consumeNonce(token, nonce, "GetSSHCertificate")
+	if err != nil {
+		zap.L().Debug(fmt.Sprintf("GetSSHCertificate(tokenString=%s,principals=%s)->%s", tokenString, principals, "Error:"+err.Error()))
+		return nil, err
+	}
+
+	zap.L().Debug(fmt.Sprintf("GetSSHCertificate(tokenString=%s,principals=%s)->%s", tokenString, cert.Principals, "Granted"))
+	return cert, nil
+}
+
+// getNonce returns the nonce that is the audience of the token if it is bound
+// to the identity of the token. A nonce that has already been consumed is
+// logged as a replay and a nonce that was issued to another identity is
+// logged as a binding mismatch.
+func (t *Cache) getNonce(token *token.Token, request string) (*nonce.Nonce, error) {
+	result, err := t.nonce.GetBoundNonce(token.Aud, t.getBinding(token))
+	switch err {
+	case nonce.ErrReplay:
+		zap.L().Warn(fmt.Sprintf("%s rejected as replay; iss=%s, sub=%v, nonce=%s", request, token.Iss, token.Claims["sub"], token.Aud))
+	case nonce.ErrBinding:
+		zap.L().Warn(fmt.Sprintf("%s rejected as nonce is bound to another identity; iss=%s, sub=%v, nonce=%s", request, token.Iss, token.Claims["sub"], token.Aud))
+	}
+	return result, err
+}
+
+// getBinding returns the values of the binding claims of the token. A claim
+// that is missing is bound to the empty string.
+func (t *Cache) getBinding(token *token.Token) map[string]string {
+
+	if len(t.binding) == 0 {
+		return nil
+	}
+
+	binding := make(map[string]string)
+	for _, claim := range t.binding {
+		value, exist := token.Claims[claim]
+		if !exist || value == nil {
+			binding[claim] = ""
+			continue
+		}
+		if s, ok := value.(string); ok {
+			binding[claim] = s
+			continue
+		}
+		binding[claim] = fmt.Sprintf("%v", value)
+	}
+
+	return binding
+}
+
+// consumeNonce consumes the nonce once the request has succeeded so that the
+// token may not be used again if nonces are single use. If another request
+// consumed the nonce first this is logged as a replay.
+func (t *Cache) consumeNonce(token *token.Token, value *nonce.Nonce, request string) error {
+	err := t.nonce.Consume(value.Value)
+	if err == nonce.ErrReplay {
+		zap.L().Warn(fmt.Sprintf("%s rejected as replay; iss=%s, sub=%v, nonce=%s", request, token.Iss, token.Claims["sub"], token.Aud))
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
+	"github.com/jodydadescott/tokens2secrets/internal/event"
+	"github.com/jodydadescott/tokens2secrets/internal/keytab"
+	"github.com/jodydadescott/tokens2secrets/internal/nonce"
+	"github.com/jodydadescott/tokens2secrets/internal/policy"
+	"github.com/jodydadescott/tokens2secrets/internal/publickey"
+	"github.com/jodydadescott/tokens2secrets/internal/token"
+)
+
+var testPolicy = `
+package main
+
+default auth_get_nonce = false
+default auth_get_keytab = false
+default auth_get_secret = false
+
+auth_get_nonce {
+   input.claims.iss == "https://issuer.example.com"
+}
+
+auth_get_keytab {
+   input.claims.iss == "https://issuer.example.com"
+   input.claims.aud == input.nonce
+   split(input.claims.service.keytab,",")[_] == input.principal
+}
+`
+
+// newTestCache returns a Cache with a native keytab for user1 and user2 and
+// single use nonces. The keytabs are generated before it is returned.
+func newTestCache(t *testing.T, fake *clock.Fake) *Cache {
+
+	keyCache := publickey.Dummy()
+	tokenCache, err := (&token.Config{Clock: fake}).Build(keyCache)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	nonceCache, err := (&nonce.Config{Clock: fake, SingleUse: true}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	var keytabs []*keytab.Keytab
+	for _, principal := range []string{"user1@EXAMPLE.COM", "user2@EXAMPLE.COM"} {
+		keytabs = append(keytabs, &keytab.Keytab{
+			Principal: principal,
+			Seed:      "nIKSXX9nJU5klguCrzP3d",
+			Lifetime:  time.Hour,
+			Backend:   keytab.BackendNative,
+		})
+	}
+
+	sink := &channelSink{c: make(chan *event.Event, 10)}
+	events, err := (&event.Config{Sinks: []event.Sink{sink}}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	keytabCache, err := (&keytab.Config{Keytabs: keytabs, Clock: fake, Events: events}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	policy, err := (&policy.Config{Policy: testPolicy}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// The keytabs are generated on the next tick of the run loop
+	fake.Advance(time.Second)
+	for range keytabs {
+		select {
+		case e := <-sink.c:
+			if e.Type != event.TypeRotated {
+				t.Fatalf("Expected event %s, got %s", event.TypeRotated, e.Type)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for keytabs")
+		}
+	}
+
+	return &Cache{
+		token:     tokenCache,
+		keytab:    keytabCache,
+		nonce:     nonceCache,
+		publickey: keyCache,
+		policy:    policy,
+		binding:   []string{"iss", "sub"},
+		events:    events,
+	}
+}
+
+// channelSink delivers each event on a channel
+type channelSink struct {
+	c chan *event.Event
+}
+
+func (t *channelSink) Send(e *event.Event) error {
+	t.c <- e
+	return nil
+}
+
+func TestGetKeytabs(t *testing.T) {
+
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 10, 30, 0, time.UTC))
+	cache := newTestCache(t, fake)
+	defer cache.Shutdown()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	cache.publickey.PutKey(&publickey.PublicKey{
+		EcdsaPublicKey: privateKey.Public().(*ecdsa.PublicKey),
+		Iss:            "https://issuer.example.com",
+		Kid:            "test",
+		Kty:            "EC",
+		Exp:            fake.Now().Add(time.Hour).Unix(),
+	})
+
+	newToken := func(aud string) string {
+		claims := jwt.MapClaims{
+			"iss": "https://issuer.example.com",
+			"sub": "alice",
+			"exp": fake.Now().Add(10 * time.Minute).Unix(),
+			"service": map[string]interface{}{
+				"keytab": "user1@EXAMPLE.COM,user3@EXAMPLE.COM",
+			},
+		}
+		if aud != "" {
+			claims["aud"] = aud
+		}
+		jwtToken := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		jwtToken.Header["kid"] = "test"
+		tokenString, err := jwtToken.SignedString(privateKey)
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+		return tokenString
+	}
+
+	ctx := context.Background()
+
+	// Nothing is granted so the nonce is not consumed
+	value, err := cache.GetNonce(ctx, newToken(""))
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	tokenString := newToken(value.Value)
+
+	batch, err := cache.GetKeytabs(ctx, tokenString, []string{"user2@EXAMPLE.COM", "user4@EXAMPLE.COM"})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if len(batch.Keytabs) != 0 || len(batch.Errors) != 2 {
+		t.Fatalf("Expected all principals to be denied, got %s", batch.JSON())
+	}
+
+	if _, err := cache.nonce.GetNonce(value.Value); err != nil {
+		t.Fatalf("Expected nonce not to be consumed, got %v", err)
+	}
+
+	// user1 is granted, user2 is denied by the policy and user3 is allowed by
+	// the policy but does not exist
+	batch, err = cache.GetKeytabs(ctx, tokenString, []string{"user1@EXAMPLE.COM", "user2@EXAMPLE.COM", "user3@EXAMPLE.COM"})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if len(batch.Keytabs) != 1 || batch.Keytabs["user1@EXAMPLE.COM"] == nil {
+		t.Fatalf("Expected keytab for user1, got %s", batch.JSON())
+	}
+
+	if batch.Errors["user2@EXAMPLE.COM"] != policy.ErrDenied.Error() {
+		t.Fatalf("Expected user2 to be denied, got %s", batch.JSON())
+	}
+
+	if batch.Errors["user3@EXAMPLE.COM"] == "" {
+		t.Fatalf("Expected error for user3, got %s", batch.JSON())
+	}
+
+	// The nonce is consumed once for the batch
+	if _, err := cache.GetKeytabs(ctx, tokenString, []string{"user1@EXAMPLE.COM"}); err != nonce.ErrReplay {
+		t.Fatalf("Expected ErrReplay, got %v", err)
+	}
+
+}
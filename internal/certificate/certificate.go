@@ -0,0 +1,67 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificate
+
+import (
+	"encoding/json"
+
+	"github.com/jinzhu/copier"
+)
+
+// Certificate An issued certificate. Certificate is the PEM encoded
+// certificate and CA the PEM encoded certificate of the issuing CA. Exp is the
+// expiration in UNIX seconds.
+type Certificate struct {
+	Certificate string `json:"certificate,omitempty" yaml:"certificate,omitempty"`
+	CA          string `json:"ca,omitempty" yaml:"ca,omitempty"`
+	Serial      string `json:"serial,omitempty" yaml:"serial,omitempty"`
+	Exp         int64  `json:"exp,omitempty" yaml:"exp,omitempty"`
+}
+
+// Constraints Names and lifetime a certificate is limited to. These are the
+// output of the policy. A DNS name may be a wildcard such as *.example.com
+// which allows any single label. An IP address may be a CIDR.
+type Constraints struct {
+	CommonNames []string `json:"common_names,omitempty" yaml:"common_names,omitempty"`
+	DNSNames    []string `json:"dns_names,omitempty" yaml:"dns_names,omitempty"`
+	IPAddresses []string `json:"ip_addresses,omitempty" yaml:"ip_addresses,omitempty"`
+	URIs        []string `json:"uris,omitempty" yaml:"uris,omitempty"`
+	Emails      []string `json:"emails,omitempty" yaml:"emails,omitempty"`
+	MaxLifetime int64    `json:"max_lifetime,omitempty" yaml:"max_lifetime,omitempty"`
+}
+
+// Request Names requested in a CSR. This is provided to the policy.
+type Request struct {
+	CommonName  string   `json:"common_name,omitempty" yaml:"common_name,omitempty"`
+	DNSNames    []string `json:"dns_names,omitempty" yaml:"dns_names,omitempty"`
+	IPAddresses []string `json:"ip_addresses,omitempty" yaml:"ip_addresses,omitempty"`
+	URIs        []string `json:"uris,omitempty" yaml:"uris,omitempty"`
+	Emails      []string `json:"emails,omitempty" yaml:"emails,omitempty"`
+}
+
+// JSON Return JSON String representation
+func (t *Certificate) JSON() string {
+	j, _ := json.Marshal(t)
+	return string(j)
+}
+
+// Copy return copy of entity
+func (t *Certificate) Copy() *Certificate {
+	clone := &Certificate{}
+	copier.Copy(&clone, &t)
+	return clone
+}
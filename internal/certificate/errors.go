@@ -0,0 +1,33 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificate
+
+import "errors"
+
+var (
+	// ErrNotConfigured Certificate issuance is not configured
+	ErrNotConfigured error = errors.New("Certificate issuance is not configured")
+
+	// ErrInvalidCSR CSR could not be parsed or the signature is invalid
+	ErrInvalidCSR error = errors.New("CSR is invalid")
+
+	// ErrNameNotAllowed CSR contains a name that is not allowed by policy
+	ErrNameNotAllowed error = errors.New("CSR contains a name that is not allowed")
+
+	// ErrNoNames CSR does not request any name
+	ErrNoNames error = errors.New("CSR does not contain a name")
+)
@@ -0,0 +1,372 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificate
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLifetime    = time.Duration(1) * time.Hour
+	defaultMaxLifetime = time.Duration(24) * time.Hour
+
+	// Certificates are back dated to allow for clock skew
+	backdate = time.Duration(1) * time.Minute
+)
+
+// Config Configuration
+//
+// CACert: PEM encoded certificate of the CA
+//
+// CAKey: PEM encoded private key of the CA (PKCS1, PKCS8 or EC)
+//
+// Lifetime: Lifetime of a certificate if the request does not specify one.
+// Default is one hour
+//
+// MaxLifetime: Maximum lifetime of a certificate regardless of the policy.
+// Default is 24 hours
+type Config struct {
+	CACert      string
+	CAKey       string
+	Lifetime    time.Duration
+	MaxLifetime time.Duration
+}
+
+// Issuer Issues short lived certificates signed by the CA. A certificate is
+// only issued for the names in the CSR that are allowed by the Constraints
+// from the policy.
+type Issuer struct {
+	caCert      *x509.Certificate
+	caPEM       string
+	caKey       crypto.Signer
+	lifetime    time.Duration
+	maxLifetime time.Duration
+}
+
+// Build Returns a new Issuer
+func (config *Config) Build() (*Issuer, error) {
+
+	zap.L().Debug("Starting")
+
+	if config.CACert == "" {
+		return nil, fmt.Errorf("CACert is required")
+	}
+
+	if config.CAKey == "" {
+		return nil, fmt.Errorf("CAKey is required")
+	}
+
+	block, _ := pem.Decode([]byte(config.CACert))
+	if block == nil {
+		return nil, fmt.Errorf("CACert is not PEM encoded")
+	}
+
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("CACert is not a CA certificate")
+	}
+
+	caKey, err := parsePrivateKey(config.CAKey)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := defaultLifetime
+	if config.Lifetime > 0 {
+		lifetime = config.Lifetime
+	}
+
+	maxLifetime := defaultMaxLifetime
+	if config.MaxLifetime > 0 {
+		maxLifetime = config.MaxLifetime
+	}
+
+	if lifetime > maxLifetime {
+		return nil, fmt.Errorf("Lifetime is greater then MaxLifetime")
+	}
+
+	return &Issuer{
+		caCert:      caCert,
+		caPEM:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})),
+		caKey:       caKey,
+		lifetime:    lifetime,
+		maxLifetime: maxLifetime,
+	}, nil
+}
+
+func parsePrivateKey(input string) (crypto.Signer, error) {
+
+	block, _ := pem.Decode([]byte(input))
+	if block == nil {
+		return nil, fmt.Errorf("CAKey is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("CAKey is not a supported private key")
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CAKey is not a supported private key")
+	}
+
+	return signer, nil
+}
+
+// ParseCSR Returns the CSR and the names requested in it. The signature of the
+// CSR is verified.
+func ParseCSR(input string) (*x509.CertificateRequest, *Request, error) {
+
+	block, _ := pem.Decode([]byte(input))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, nil, ErrInvalidCSR
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, ErrInvalidCSR
+	}
+
+	err = csr.CheckSignature()
+	if err != nil {
+		return nil, nil, ErrInvalidCSR
+	}
+
+	request := &Request{
+		CommonName: csr.Subject.CommonName,
+		DNSNames:   csr.DNSNames,
+		Emails:     csr.EmailAddresses,
+	}
+
+	for _, ip := range csr.IPAddresses {
+		request.IPAddresses = append(request.IPAddresses, ip.String())
+	}
+
+	for _, uri := range csr.URIs {
+		request.URIs = append(request.URIs, uri.String())
+	}
+
+	return csr, request, nil
+}
+
+// Issue Returns a certificate for the CSR if each name in the CSR is allowed
+// by the constraints. The lifetime is the requested lifetime (or the default)
+// limited by the constraints and the MaxLifetime.
+func (t *Issuer) Issue(csr *x509.CertificateRequest, constraints *Constraints, lifetime time.Duration) (*Certificate, error) {
+
+	if t == nil {
+		return nil, ErrNotConfigured
+	}
+
+	if constraints == nil {
+		constraints = &Constraints{}
+	}
+
+	err := checkNames(csr, constraints)
+	if err != nil {
+		return nil, err
+	}
+
+	if lifetime <= 0 {
+		lifetime = t.lifetime
+	}
+
+	if lifetime > t.maxLifetime {
+		lifetime = t.maxLifetime
+	}
+
+	if constraints.MaxLifetime > 0 && lifetime > time.Duration(constraints.MaxLifetime)*time.Second {
+		lifetime = time.Duration(constraints.MaxLifetime) * time.Second
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(lifetime)
+
+	// The certificate can not outlive the CA
+	if notAfter.After(t.caCert.NotAfter) {
+		notAfter = t.caCert.NotAfter
+	}
+
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		keyUsage = keyUsage | x509.KeyUsageKeyEncipherment
+	}
+
+	subjectKeyID, err := keyID(csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: csr.Subject.CommonName},
+		NotBefore:      now.Add(-backdate),
+		NotAfter:       notAfter,
+		KeyUsage:       keyUsage,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		URIs:           csr.URIs,
+		EmailAddresses: csr.EmailAddresses,
+		SubjectKeyId:   subjectKeyID,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, t.caCert, csr.PublicKey, t.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug(fmt.Sprintf("Issued certificate serial=%x, cn=%s, exp=%d", serial, csr.Subject.CommonName, notAfter.Unix()))
+
+	return &Certificate{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		CA:          t.caPEM,
+		Serial:      hex.EncodeToString(serial.Bytes()),
+		Exp:         notAfter.Unix(),
+	}, nil
+}
+
+// keyID returns the subject key identifier for the public key (RFC 5280
+// 4.2.1.2 method 1)
+func keyID(publicKey interface{}) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, ErrInvalidCSR
+	}
+	hash := sha1.Sum(der)
+	return hash[:], nil
+}
+
+// checkNames returns an error if any name in the CSR is not allowed or if the
+// CSR does not contain a name so that a certificate is never issued without a
+// name that was checked against the policy
+func checkNames(csr *x509.CertificateRequest, constraints *Constraints) error {
+
+	if csr.Subject.CommonName == "" && len(csr.DNSNames) == 0 && len(csr.IPAddresses) == 0 && len(csr.URIs) == 0 && len(csr.EmailAddresses) == 0 {
+		return ErrNoNames
+	}
+
+	if csr.Subject.CommonName != "" {
+		if !contains(constraints.CommonNames, csr.Subject.CommonName) && !matchDNSName(constraints.DNSNames, csr.Subject.CommonName) {
+			return fmt.Errorf("%s; common name %s", ErrNameNotAllowed, csr.Subject.CommonName)
+		}
+	}
+
+	for _, name := range csr.DNSNames {
+		if !matchDNSName(constraints.DNSNames, name) {
+			return fmt.Errorf("%s; DNS name %s", ErrNameNotAllowed, name)
+		}
+	}
+
+	for _, ip := range csr.IPAddresses {
+		if !matchIP(constraints.IPAddresses, ip) {
+			return fmt.Errorf("%s; IP address %s", ErrNameNotAllowed, ip)
+		}
+	}
+
+	for _, uri := range csr.URIs {
+		if !contains(constraints.URIs, uri.String()) {
+			return fmt.Errorf("%s; URI %s", ErrNameNotAllowed, uri)
+		}
+	}
+
+	for _, email := range csr.EmailAddresses {
+		if !contains(constraints.Emails, email) {
+			return fmt.Errorf("%s; email %s", ErrNameNotAllowed, email)
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDNSName returns true if the name is in the list. A wildcard in the
+// list matches a single label.
+func matchDNSName(list []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range list {
+		s = strings.ToLower(s)
+		if s == name {
+			return true
+		}
+		if strings.HasPrefix(s, "*.") {
+			i := strings.Index(name, ".")
+			if i > 0 && name[i:] == s[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchIP returns true if the IP is in the list. An entry may be an address
+// or a CIDR.
+func matchIP(list []string, ip net.IP) bool {
+	for _, s := range list {
+		if strings.Contains(s, "/") {
+			_, network, err := net.ParseCIDR(s)
+			if err == nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if allowed := net.ParseIP(s); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
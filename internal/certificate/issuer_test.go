@@ -0,0 +1,178 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func newCA(t *testing.T) *Config {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 48),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	return &Config{
+		CACert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		CAKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+func newCSR(t *testing.T, cn string, dnsNames []string, ips []net.IP) string {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: cn},
+		DNSNames:    dnsNames,
+		IPAddresses: ips,
+	}, key)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestIssue(t *testing.T) {
+
+	issuer, err := newCA(t).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	csr, request, err := ParseCSR(newCSR(t, "www.example.com", []string{"www.example.com", "a.api.example.com"}, []net.IP{net.ParseIP("10.0.0.5")}))
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if request.CommonName != "www.example.com" || len(request.DNSNames) != 2 || request.IPAddresses[0] != "10.0.0.5" {
+		t.Fatalf("Unexpected request %+v", request)
+	}
+
+	constraints := &Constraints{
+		DNSNames:    []string{"www.example.com", "*.api.example.com"},
+		IPAddresses: []string{"10.0.0.0/24"},
+		MaxLifetime: 600,
+	}
+
+	result, err := issuer.Issue(csr, constraints, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	block, _ := pem.Decode([]byte(result.Certificate))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// The requested hour is limited to the ten minutes allowed by the policy
+	if cert.NotAfter.Sub(time.Now()) > time.Minute*10 {
+		t.Fatalf("Expected lifetime to be limited to 10 minutes, expires %s", cert.NotAfter)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(result.CA))
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "a.api.example.com", Roots: roots})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+}
+
+func TestIssueDenied(t *testing.T) {
+
+	issuer, err := newCA(t).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	constraints := &Constraints{
+		DNSNames:    []string{"*.api.example.com"},
+		IPAddresses: []string{"10.0.0.5"},
+	}
+
+	requests := []string{
+		newCSR(t, "", []string{"www.example.com"}, nil),
+		newCSR(t, "", []string{"a.b.api.example.com"}, nil),
+		newCSR(t, "", nil, []net.IP{net.ParseIP("10.0.0.6")}),
+		newCSR(t, "admin", []string{"a.api.example.com"}, nil),
+	}
+
+	for _, s := range requests {
+		csr, _, err := ParseCSR(s)
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+		if _, err := issuer.Issue(csr, constraints, 0); err == nil {
+			t.Fatalf("Expected names to be denied")
+		}
+	}
+
+	// A CSR without any name is not issued
+	csr, _, err := ParseCSR(newCSR(t, "", nil, nil))
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	if _, err := issuer.Issue(csr, constraints, 0); err != ErrNoNames {
+		t.Fatalf("Expected ErrNoNames, got %v", err)
+	}
+
+	if _, _, err := ParseCSR("not a csr"); err != ErrInvalidCSR {
+		t.Fatalf("Expected ErrInvalidCSR")
+	}
+
+	var notConfigured *Issuer
+	if _, err := notConfigured.Issue(nil, constraints, 0); err != ErrNotConfigured {
+		t.Fatalf("Expected ErrNotConfigured")
+	}
+
+}
@@ -0,0 +1,165 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock Source of the current time and of tickers. The caches take a Clock
+// in their Config so that rotation, half life, expiry and eviction may be
+// tested with a Fake. If a Config does not set a Clock then Real is used.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker Delivers the time on C at intervals until stopped
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real Returns the Clock of the system
+func Real() Clock {
+	return &realClock{}
+}
+
+type realClock struct{}
+
+func (t *realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (t *realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t *realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// Fake Clock that only moves when it is Set or Advanced. Tickers created
+// from a Fake fire as the time passes their next tick. Like a real ticker a
+// tick is dropped if the previous one has not been received.
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFake Returns a Fake set to now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now Returns the time of the Fake
+func (t *Fake) Now() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.now
+}
+
+// Advance Moves the time forward by d and fires the tickers that are due
+func (t *Fake) Advance(d time.Duration) {
+	t.Set(t.Now().Add(d))
+}
+
+// Set Sets the time and fires the tickers that are due
+func (t *Fake) Set(now time.Time) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.now = now
+
+	for _, ticker := range t.tickers {
+		ticker.fire(now)
+	}
+}
+
+// NewTicker Returns a Ticker that fires each time the Fake passes d
+func (t *Fake) NewTicker(d time.Duration) Ticker {
+
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ticker := &fakeTicker{
+		fake:     t,
+		c:        make(chan time.Time, 1),
+		interval: d,
+		next:     t.now.Add(d),
+	}
+
+	t.tickers = append(t.tickers, ticker)
+	return ticker
+}
+
+func (t *Fake) remove(ticker *fakeTicker) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i, v := range t.tickers {
+		if v == ticker {
+			t.tickers = append(t.tickers[:i], t.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	fake     *Fake
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+}
+
+// fire sends a tick if the ticker is due. Fake must be locked.
+func (t *fakeTicker) fire(now time.Time) {
+
+	if now.Before(t.next) {
+		return
+	}
+
+	select {
+	case t.c <- now:
+	default:
+	}
+
+	// Ticks that were missed are skipped
+	t.next = t.next.Add((now.Sub(t.next)/t.interval + 1) * t.interval)
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.fake.remove(t)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+
+	start := time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC)
+	fake := NewFake(start)
+
+	ticker := fake.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	fake.Advance(30 * time.Second)
+
+	select {
+	case <-ticker.C():
+		t.Fatalf("Unexpected tick before interval")
+	default:
+	}
+
+	// A tick is dropped if the previous one was not received
+	fake.Advance(5 * time.Minute)
+
+	select {
+	case now := <-ticker.C():
+		if !now.Equal(start.Add(330 * time.Second)) {
+			t.Fatalf("Unexpected tick time %s", now)
+		}
+	default:
+		t.Fatalf("Expected tick")
+	}
+
+	select {
+	case <-ticker.C():
+		t.Fatalf("Expected only one tick")
+	default:
+	}
+
+	fake.Advance(time.Minute)
+
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatalf("Expected tick")
+	}
+
+	if !fake.Now().Equal(start.Add(390 * time.Second)) {
+		t.Fatalf("Unexpected now %s", fake.Now())
+	}
+
+}
@@ -19,6 +19,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"runtime"
@@ -26,7 +27,9 @@ import (
 	"syscall"
 
 	"github.com/jodydadescott/tokens2secrets/config"
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
 	"github.com/jodydadescott/tokens2secrets/internal/server"
+	"github.com/jodydadescott/tokens2secrets/internal/store"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -203,6 +206,219 @@ var configMakeCmd = &cobra.Command{
 	},
 }
 
+var configSealCmd = &cobra.Command{
+	Use:   "seal",
+	Short: "encrypt seeds in configuration with master key",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		configLoader := server.NewLoader()
+
+		if viper.GetString("config") == "" {
+			return errors.New("config required")
+		}
+
+		for _, s := range strings.Split(viper.GetString("config"), ",") {
+			err := configLoader.LoadFrom(s)
+			if err != nil {
+				return err
+			}
+		}
+
+		count, err := configLoader.Seal()
+		if err != nil {
+			return err
+		}
+
+		configString := ""
+		switch strings.ToLower(viper.GetString("format")) {
+
+		case "", "yaml":
+			configString = configLoader.Config.YAML()
+			break
+
+		case "json":
+			configString = configLoader.Config.JSON()
+			break
+
+		default:
+			return fmt.Errorf(fmt.Sprintf("Output format %s is unknown. Must be yaml or json", viper.GetString("format")))
+		}
+
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Sealed %d seeds", count))
+		fmt.Print(configString)
+		return nil
+
+	},
+}
+
+var configKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "generate master key",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := masterkey.Generate()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	},
+}
+
+var secretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "manage stored secrets",
+}
+
+var secretPutCmd = &cobra.Command{
+	Use:   "put NAME [VALUE]",
+	Short: "store new secret; value is read from stdin if not provided",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(args) < 1 {
+			return errors.New("name required")
+		}
+
+		value, err := getSecretValue(args, true)
+		if err != nil {
+			return err
+		}
+
+		store, err := getStore()
+		if err != nil {
+			return err
+		}
+
+		result, err := store.Put(args[0], value)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(fmt.Sprintf("Stored secret %s version %d", result.Name, result.Version))
+		return nil
+	},
+}
+
+var secretRotateCmd = &cobra.Command{
+	Use:   "rotate NAME [VALUE]",
+	Short: "replace stored secret; a random value is generated if not provided",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(args) < 1 {
+			return errors.New("name required")
+		}
+
+		value, err := getSecretValue(args, false)
+		if err != nil {
+			return err
+		}
+
+		store, err := getStore()
+		if err != nil {
+			return err
+		}
+
+		result, err := store.Rotate(args[0], value)
+		if err != nil {
+			return err
+		}
+
+		if value == "" {
+			fmt.Println(result.Value)
+		}
+
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Rotated secret %s to version %d", result.Name, result.Version))
+		return nil
+	},
+}
+
+var secretDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "delete stored secret",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if len(args) < 1 {
+			return errors.New("name required")
+		}
+
+		store, err := getStore()
+		if err != nil {
+			return err
+		}
+
+		err = store.Delete(args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(fmt.Sprintf("Deleted secret %s", args[0]))
+		return nil
+	},
+}
+
+// getStore returns the store from the configuration
+func getStore() (*store.Store, error) {
+
+	configLoader := server.NewLoader()
+
+	configString := viper.GetString("config")
+	if configString == "" {
+		var err error
+		configString, err = GetRuntimeConfigString()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range strings.Split(configString, ",") {
+		err := configLoader.LoadFrom(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	storeConfig, err := configLoader.StoreConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if storeConfig == nil {
+		return nil, errors.New("store path is not configured")
+	}
+
+	return storeConfig.Build()
+}
+
+// getSecretValue returns the value from the args. If it is not in the args
+// and stdin is required then it is read from stdin so that it is not exposed
+// in the process list or shell history
+func getSecretValue(args []string, stdin bool) (string, error) {
+
+	if len(args) > 1 {
+		return args[1], nil
+	}
+
+	if !stdin {
+		return "", nil
+	}
+
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+
+	value := strings.TrimRight(string(content), "\r\n")
+	if value == "" {
+		return "", errors.New("value required")
+	}
+
+	return value, nil
+}
+
 var windowsRunDebugCmd = &cobra.Command{
 	Use:   "run-debug",
 	Short: "run debug (non service)",
@@ -358,13 +574,15 @@ func init() {
 	if runtime.GOOS == "windows" {
 
 		serviceCmd.AddCommand(serviceInstallCmd, serviceRemoveCmd, serviceStartCmd, serviceStopCmd, servicePauseCmd, serviceContinueCmd, serviceConfigSetCmd, serviceConfigShowCmd)
-		configCmd.AddCommand(configExampleCmd, configMakeCmd)
-		rootCmd.AddCommand(serviceCmd, configCmd, windowsRunDebugCmd)
+		configCmd.AddCommand(configExampleCmd, configMakeCmd, configSealCmd, configKeygenCmd)
+		secretCmd.AddCommand(secretPutCmd, secretRotateCmd, secretDeleteCmd)
+		rootCmd.AddCommand(serviceCmd, configCmd, secretCmd, windowsRunDebugCmd)
 
 	} else {
 
-		configCmd.AddCommand(configMakeCmd, configExampleCmd)
-		rootCmd.AddCommand(configCmd, serverCmd)
+		configCmd.AddCommand(configMakeCmd, configExampleCmd, configSealCmd, configKeygenCmd)
+		secretCmd.AddCommand(secretPutCmd, secretRotateCmd, secretDeleteCmd)
+		rootCmd.AddCommand(configCmd, secretCmd, serverCmd)
 
 	}
 
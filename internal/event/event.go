@@ -0,0 +1,71 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"encoding/json"
+
+	"github.com/jinzhu/copier"
+)
+
+const (
+	// TypeRotated A new keytab was generated or a secret rolled over to a new period
+	TypeRotated = "rotated"
+
+	// TypeFailed Generation failed
+	TypeFailed = "failed"
+
+	// TypeExpired The period of a keytab or secret ended
+	TypeExpired = "expired"
+
+	// TypeDiverged The password differs from a peer instance
+	TypeDiverged = "diverged"
+)
+
+const (
+	// KindKeytab Event is for a keytab
+	KindKeytab = "keytab"
+
+	// KindSecret Event is for a secret
+	KindSecret = "secret"
+)
+
+// Event is sent to each Sink. Name is the principal of the keytab or the name
+// of the secret. Time and Exp are in UNIX seconds. Secrets and passwords are
+// never included.
+type Event struct {
+	Type  string `json:"type,omitempty" yaml:"type,omitempty"`
+	Kind  string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"`
+	Time  int64  `json:"time,omitempty" yaml:"time,omitempty"`
+	Exp   int64  `json:"exp,omitempty" yaml:"exp,omitempty"`
+	Kvno  int    `json:"kvno,omitempty" yaml:"kvno,omitempty"`
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// JSON Return JSON String representation
+func (t *Event) JSON() string {
+	j, _ := json.Marshal(t)
+	return string(j)
+}
+
+// Copy return copy of entity
+func (t *Event) Copy() *Event {
+	clone := &Event{}
+	copier.Copy(&clone, &t)
+	return clone
+}
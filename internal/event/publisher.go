@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultQueueSize = 1000
+
+// Config Configuration
+//
+// Exec: Zero or more commands that are ran for each event
+//
+// Webhooks: Zero or more URLs that each event is posted to
+//
+// Files: Zero or more files that each event is appended to
+//
+// Sinks: Zero or more additional Sinks
+//
+// QueueSize: Maximum number of events waiting to be sent. Events are dropped
+// when the queue is full. Default is 1000
+type Config struct {
+	Exec      []*Exec
+	Webhooks  []*Webhook
+	Files     []*File
+	Sinks     []Sink
+	QueueSize int
+}
+
+// Publisher sends events to each Sink. Events are queued and sent in the
+// background in the order they were published so that publishing never
+// blocks the caller. A nil Publisher is valid and discards all events.
+type Publisher struct {
+	sinks  []Sink
+	queue  chan *Event
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Build Returns a new Publisher
+func (config *Config) Build() (*Publisher, error) {
+
+	zap.L().Debug("Starting")
+
+	t := &Publisher{
+		closed: make(chan struct{}),
+	}
+
+	for _, sink := range config.Exec {
+		if sink.Command == "" {
+			return nil, fmt.Errorf("Exec command is required")
+		}
+		t.sinks = append(t.sinks, sink)
+	}
+
+	for _, sink := range config.Webhooks {
+		if sink.URL == "" {
+			return nil, fmt.Errorf("Webhook URL is required")
+		}
+		t.sinks = append(t.sinks, sink)
+	}
+
+	for _, sink := range config.Files {
+		if sink.Path == "" {
+			return nil, fmt.Errorf("File path is required")
+		}
+		t.sinks = append(t.sinks, sink)
+	}
+
+	for _, sink := range config.Sinks {
+		if sink == nil {
+			return nil, fmt.Errorf("Sink is nil")
+		}
+		t.sinks = append(t.sinks, sink)
+	}
+
+	queueSize := defaultQueueSize
+	if config.QueueSize > 0 {
+		queueSize = config.QueueSize
+	}
+
+	t.queue = make(chan *Event, queueSize)
+
+	t.wg.Add(1)
+	go t.run()
+
+	return t, nil
+}
+
+// Publish queues the event to be sent to each Sink. If Time is not set it is
+// set to now.
+func (t *Publisher) Publish(event *Event) error {
+
+	if t == nil || len(t.sinks) == 0 {
+		return nil
+	}
+
+	event = event.Copy()
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+
+	select {
+	case <-t.closed:
+		return nil
+	default:
+	}
+
+	select {
+	case t.queue <- event:
+		return nil
+	default:
+		zap.L().Error(fmt.Sprintf("Dropping event %s; err->%s", event.JSON(), ErrQueueFull))
+		return ErrQueueFull
+	}
+}
+
+func (t *Publisher) run() {
+	defer t.wg.Done()
+	for {
+		select {
+		case <-t.closed:
+			// Send what is already queued before stopping
+			for {
+				select {
+				case event := <-t.queue:
+					t.send(event)
+				default:
+					return
+				}
+			}
+		case event := <-t.queue:
+			t.send(event)
+		}
+	}
+}
+
+func (t *Publisher) send(event *Event) {
+	for _, sink := range t.sinks {
+		err := sink.Send(event)
+		if err != nil {
+			zap.L().Error(fmt.Sprintf("Unable to send event %s; err->%s", event.JSON(), err.Error()))
+			continue
+		}
+		zap.L().Debug(fmt.Sprintf("Sent event %s", event.JSON()))
+	}
+}
+
+// Shutdown sends the events that are queued and stops
+func (t *Publisher) Shutdown() {
+	if t == nil {
+		return
+	}
+	zap.L().Debug("Stopping")
+	close(t.closed)
+	t.wg.Wait()
+}
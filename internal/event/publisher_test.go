@@ -0,0 +1,117 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPublisher(t *testing.T) {
+
+	received := make(chan *Event, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		event := &Event{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "event")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "events.log")
+	execFilename := filepath.Join(dir, "exec.log")
+
+	config := &Config{
+		Webhooks: []*Webhook{&Webhook{URL: server.URL, Headers: map[string]string{"X-Token": "abc"}}},
+		Files:    []*File{&File{Path: filename}},
+		Exec:     []*Exec{&Exec{Command: "/bin/sh", Args: []string{"-c", "cat >> " + execFilename}}},
+	}
+
+	publisher, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	publisher.Publish(&Event{Type: TypeRotated, Kind: KindKeytab, Name: "bob@EXAMPLE.COM", Kvno: 2})
+	publisher.Publish(&Event{Type: TypeFailed, Kind: KindSecret, Name: "secret1", Error: "failed"})
+
+	// Shutdown sends what is queued
+	publisher.Shutdown()
+
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 events from webhook, got %d", len(received))
+	}
+
+	event := <-received
+	if event.Type != TypeRotated || event.Name != "bob@EXAMPLE.COM" || event.Kvno != 2 || event.Time == 0 {
+		t.Fatalf("Unexpected event %s", event.JSON())
+	}
+
+	for _, name := range []string{filename, execFilename} {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(lines) != 2 || !strings.Contains(lines[1], "\"error\":\"failed\"") {
+			t.Fatalf("Unexpected content in %s: %s", name, string(content))
+		}
+	}
+
+	// A nil Publisher discards events
+	var nilPublisher *Publisher
+	if err := nilPublisher.Publish(&Event{}); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if _, err := (&Config{Webhooks: []*Webhook{&Webhook{}}}).Build(); err == nil {
+		t.Fatalf("Expected error for webhook without URL")
+	}
+
+}
+
+func TestWebhookStatus(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := (&Webhook{URL: server.URL}).Send(&Event{Type: TypeRotated}); err == nil {
+		t.Fatalf("Expected error for status 500")
+	}
+
+}
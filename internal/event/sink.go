@@ -0,0 +1,133 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package event
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExecTimeout    = time.Duration(30) * time.Second
+	defaultWebhookTimeout = time.Duration(10) * time.Second
+)
+
+// Sink Interface. A Sink delivers events to a system outside of the process
+type Sink interface {
+	Send(event *Event) error
+}
+
+// Exec Sink runs a local command for each event with the event as JSON on
+// stdin. The command is killed if it does not complete within Timeout
+type Exec struct {
+	Command string
+	Args    []string
+	Timeout time.Duration
+}
+
+// Send runs the command
+func (t *Exec) Send(event *Event) error {
+
+	timeout := defaultExecTimeout
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, t.Command, t.Args...)
+	cmd.Stdin = bytes.NewBufferString(event.JSON() + "\n")
+	cmdOutput := &bytes.Buffer{}
+	cmd.Stdout = cmdOutput
+	cmd.Stderr = cmdOutput
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("command %s failed; err->%s, output->%s", t.Command, err.Error(), string(cmdOutput.Bytes()))
+	}
+
+	return nil
+}
+
+// Webhook Sink posts each event as JSON to URL. Any status other then 2xx
+// is an error
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+// Send posts the event
+func (t *Webhook) Send(event *Event) error {
+
+	timeout := defaultWebhookTimeout
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewBufferString(event.JSON()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned status %d", t.URL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// File Sink appends each event as a line of JSON to Path
+type File struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+// Send appends the event
+func (t *File) Send(event *Event) error {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	f, err := os.OpenFile(t.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(event.JSON() + "\n")
+	return err
+}
@@ -19,16 +19,21 @@ package http
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/jodydadescott/tokens2secrets/internal/certificate"
 	"github.com/jodydadescott/tokens2secrets/internal/keytab"
 	"github.com/jodydadescott/tokens2secrets/internal/nonce"
+	"github.com/jodydadescott/tokens2secrets/internal/peer"
 	"github.com/jodydadescott/tokens2secrets/internal/secret"
+	"github.com/jodydadescott/tokens2secrets/internal/sshcert"
 	"go.uber.org/zap"
 )
 
@@ -36,9 +41,19 @@ import (
 type App interface {
 	GetNonce(ctx context.Context, tokenString string) (*nonce.Nonce, error)
 	GetKeytab(ctx context.Context, tokenString, principal string) (*keytab.Keytab, error)
+	GetKeytabs(ctx context.Context, tokenString string, principals []string) (*keytab.Batch, error)
+	GetKeytabHealth(ctx context.Context) []*keytab.Health
+	GetPeerHealth(ctx context.Context) []*peer.Health
+	AuthHealth(ctx context.Context, peerToken string) error
+	GetFingerprints(ctx context.Context, peerToken string) (*peer.Fingerprints, error)
 	GetSecret(ctx context.Context, tokenString, name string) (*secret.Secret, error)
+	GetCertificate(ctx context.Context, tokenString, csr string, lifetime time.Duration) (*certificate.Certificate, error)
+	GetSSHCertificate(ctx context.Context, tokenString, publicKey, certType string, principals []string, lifetime time.Duration) (*sshcert.Certificate, error)
 }
 
+// maxBodySize is the maximum size of a CSR or public key in a request body
+const maxBodySize = 64 * 1024
+
 // Config ...
 type Config struct {
 	Listen, TLSCert, TLSKey string
@@ -157,6 +172,12 @@ func (t *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
+	// Health is for operators and monitoring and does not require a token
+	if r.URL.Path == "/health" {
+		fmt.Fprintf(w, t.getHealth(r).JSON()+"\n")
+		return
+	}
+
 	token := getBearerToken(r)
 
 	if token == "" {
@@ -175,13 +196,27 @@ func (t *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	case "/getkeytab":
 
-		principal := getKey(r, "principal")
-		if principal == "" {
+		// More then one principal may be requested either by repeating the
+		// principal parameter or with a comma separated list. If so the result
+		// is a batch with a keytab or error for each principal. This is the
+		// case even if only one principal remains after removing duplicates
+		// so that the shape of the result only depends on the syntax used.
+		principals := getKeys(r, "principal")
+		if len(principals) == 0 {
 			http.Error(w, newErrorResponse("Principal required")+"\n", http.StatusConflict)
 			return
 		}
 
-		keytab, err := t.app.GetKeytab(r.Context(), token, principal)
+		if isBatch(r, "principal") {
+			batch, err := t.app.GetKeytabs(r.Context(), token, principals)
+			if handleERR(w, err) {
+				return
+			}
+			fmt.Fprintf(w, batch.JSON()+"\n")
+			return
+		}
+
+		keytab, err := t.app.GetKeytab(r.Context(), token, principals[0])
 		if handleERR(w, err) {
 			return
 		}
@@ -189,6 +224,15 @@ func (t *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, keytab.JSON()+"\n")
 		return
 
+	case "/getfingerprints":
+		// Peers authenticate with the shared peer token and not a JWT
+		result, err := t.app.GetFingerprints(r.Context(), token)
+		if handleERR(w, err) {
+			return
+		}
+		fmt.Fprintf(w, result.JSON()+"\n")
+		return
+
 	case "/getsecret":
 		name := getKey(r, "name")
 		if name == "" {
@@ -202,6 +246,59 @@ func (t *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		fmt.Fprintf(w, result.JSON()+"\n")
 		return
+
+	case "/getcertificate":
+		// The PEM encoded CSR may be sent as the body of a POST or as the
+		// parameter csr. The lifetime is optional and may be a duration such
+		// as 10m or a number of seconds
+		csr, err := getBody(w, r, "csr")
+		if handleERR(w, err) {
+			return
+		}
+
+		if csr == "" {
+			http.Error(w, newErrorResponse("CSR required")+"\n", http.StatusConflict)
+			return
+		}
+
+		lifetime, err := getDuration(r, "lifetime")
+		if handleERR(w, err) {
+			return
+		}
+
+		result, err := t.app.GetCertificate(r.Context(), token, csr, lifetime)
+		if handleERR(w, err) {
+			return
+		}
+		fmt.Fprintf(w, result.JSON()+"\n")
+		return
+
+	case "/getsshcertificate":
+		// The public key in authorized keys format may be sent as the body of
+		// a POST or as the parameter publickey. The type is user (default) or
+		// host. If principals are not requested the certificate is issued for
+		// all of the principals allowed by the policy
+		publicKey, err := getBody(w, r, "publickey")
+		if handleERR(w, err) {
+			return
+		}
+
+		if publicKey == "" {
+			http.Error(w, newErrorResponse("Public key required")+"\n", http.StatusConflict)
+			return
+		}
+
+		lifetime, err := getDuration(r, "lifetime")
+		if handleERR(w, err) {
+			return
+		}
+
+		result, err := t.app.GetSSHCertificate(r.Context(), token, publicKey, getKey(r, "type"), getKeys(r, "principal"), lifetime)
+		if handleERR(w, err) {
+			return
+		}
+		fmt.Fprintf(w, result.JSON()+"\n")
+		return
 	}
 
 	http.Error(w, newErrorResponse("Path "+r.URL.Path+" not mapped")+"\n", http.StatusConflict)
@@ -243,6 +340,124 @@ func getKey(r *http.Request, name string) string {
 	return string(keys[0])
 }
 
+// getKeys returns the unique values for the parameter. Values may be provided
+// by repeating the parameter or as a comma separated list
+func getKeys(r *http.Request, name string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, key := range r.URL.Query()[name] {
+		for _, value := range strings.Split(key, ",") {
+			value = strings.TrimSpace(value)
+			if value != "" && !seen[value] {
+				seen[value] = true
+				result = append(result, value)
+			}
+		}
+	}
+	return result
+}
+
+// health Response for /health. Without a token only the aggregate Status and
+// the number of keytabs in each state is returned. The health of each keytab
+// and peer is only returned with the peer token as it contains the principals
+// and the errors of the backends.
+type health struct {
+	Status   string           `json:"status"`
+	OK       int              `json:"ok"`
+	Pending  int              `json:"pending"`
+	Failing  int              `json:"failing"`
+	Diverged int              `json:"diverged"`
+	Keytabs  []*keytab.Health `json:"keytabs,omitempty"`
+	Peers    []*peer.Health   `json:"peers,omitempty"`
+}
+
+// JSON Return JSON String representation
+func (t *health) JSON() string {
+	j, _ := json.Marshal(t)
+	return string(j)
+}
+
+// getHealth returns the health. The Status is failing if any keytab is failing
+// or any peer has diverged, pending if any keytab is pending and otherwise ok.
+func (t *Server) getHealth(r *http.Request) *health {
+
+	keytabs := t.app.GetKeytabHealth(r.Context())
+	peers := t.app.GetPeerHealth(r.Context())
+
+	result := &health{}
+
+	for _, keytabHealth := range keytabs {
+		switch keytabHealth.State {
+		case keytab.HealthOK:
+			result.OK++
+		case keytab.HealthPending:
+			result.Pending++
+		case keytab.HealthFailing:
+			result.Failing++
+		}
+	}
+
+	for _, peerHealth := range peers {
+		if len(peerHealth.Diverged) > 0 {
+			result.Diverged++
+		}
+	}
+
+	switch {
+	case result.Failing > 0 || result.Diverged > 0:
+		result.Status = keytab.HealthFailing
+	case result.Pending > 0:
+		result.Status = keytab.HealthPending
+	default:
+		result.Status = keytab.HealthOK
+	}
+
+	token := getBearerToken(r)
+	if token != "" && t.app.AuthHealth(r.Context(), token) == nil {
+		result.Keytabs = keytabs
+		result.Peers = peers
+	}
+
+	return result
+}
+
+// isBatch returns true if the parameter is repeated or is a comma separated
+// list
+func isBatch(r *http.Request, name string) bool {
+	values := r.URL.Query()[name]
+	return len(values) > 1 || (len(values) == 1 && strings.Contains(values[0], ","))
+}
+
+// getBody returns the body of a POST or otherwise the parameter
+func getBody(w http.ResponseWriter, r *http.Request, name string) (string, error) {
+	if r.Method != http.MethodPost {
+		return getKey(r, name), nil
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// getDuration returns the parameter as a duration. The value may be a
+// duration string or a number of seconds. If the parameter is not present
+// zero is returned
+func getDuration(r *http.Request, name string) (time.Duration, error) {
+	value := getKey(r, name)
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Parameter '%s' is not a valid duration", name)
+	}
+	return duration, nil
+}
+
 // Shutdown Server
 func (t *Server) Shutdown() {
 	zap.L().Info(fmt.Sprintf("Stopping"))
@@ -0,0 +1,210 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jodydadescott/tokens2secrets/internal/certificate"
+	"github.com/jodydadescott/tokens2secrets/internal/keytab"
+	"github.com/jodydadescott/tokens2secrets/internal/nonce"
+	"github.com/jodydadescott/tokens2secrets/internal/peer"
+	"github.com/jodydadescott/tokens2secrets/internal/secret"
+	"github.com/jodydadescott/tokens2secrets/internal/sshcert"
+)
+
+// testApp records the principals requested and returns a keytab for each.
+// The peer token is peer.
+type testApp struct {
+	principals []string
+	health     []*keytab.Health
+}
+
+func (t *testApp) GetNonce(ctx context.Context, tokenString string) (*nonce.Nonce, error) {
+	return nil, nil
+}
+
+func (t *testApp) GetKeytab(ctx context.Context, tokenString, principal string) (*keytab.Keytab, error) {
+	t.principals = []string{principal}
+	return &keytab.Keytab{Principal: principal}, nil
+}
+
+func (t *testApp) GetKeytabs(ctx context.Context, tokenString string, principals []string) (*keytab.Batch, error) {
+	t.principals = principals
+	batch := &keytab.Batch{Keytabs: make(map[string]*keytab.Keytab)}
+	for _, principal := range principals {
+		batch.Keytabs[principal] = &keytab.Keytab{Principal: principal}
+	}
+	return batch, nil
+}
+
+func (t *testApp) GetKeytabHealth(ctx context.Context) []*keytab.Health {
+	return t.health
+}
+
+func (t *testApp) GetPeerHealth(ctx context.Context) []*peer.Health {
+	return nil
+}
+
+func (t *testApp) AuthHealth(ctx context.Context, peerToken string) error {
+	if peerToken != "peer" {
+		return peer.ErrDenied
+	}
+	return nil
+}
+
+func (t *testApp) GetFingerprints(ctx context.Context, peerToken string) (*peer.Fingerprints, error) {
+	return nil, nil
+}
+
+func (t *testApp) GetSecret(ctx context.Context, tokenString, name string) (*secret.Secret, error) {
+	return nil, nil
+}
+
+func (t *testApp) GetCertificate(ctx context.Context, tokenString, csr string, lifetime time.Duration) (*certificate.Certificate, error) {
+	return nil, nil
+}
+
+func (t *testApp) GetSSHCertificate(ctx context.Context, tokenString, publicKey, certType string, principals []string, lifetime time.Duration) (*sshcert.Certificate, error) {
+	return nil, nil
+}
+
+func TestGetKeys(t *testing.T) {
+
+	vectors := []struct {
+		query  string
+		expect []string
+		batch  bool
+	}{
+		{"principal=a", []string{"a"}, false},
+		{"principal=a,b", []string{"a", "b"}, true},
+		{"principal=a&principal=b", []string{"a", "b"}, true},
+		{"principal=a,%20b,,a&principal=c&principal=b", []string{"a", "b", "c"}, true},
+		{"principal=a,a", []string{"a"}, true},
+		{"principal=a&principal=a", []string{"a"}, true},
+		{"other=a", nil, false},
+	}
+
+	for _, v := range vectors {
+		r := httptest.NewRequest(http.MethodGet, "/getkeytab?"+v.query, nil)
+		if result := getKeys(r, "principal"); !reflect.DeepEqual(result, v.expect) {
+			t.Fatalf("getKeys(%s) expected %s, got %s", v.query, v.expect, result)
+		}
+		if isBatch(r, "principal") != v.batch {
+			t.Fatalf("isBatch(%s) expected %t", v.query, v.batch)
+		}
+	}
+
+}
+
+func TestGetKeytab(t *testing.T) {
+
+	vectors := []struct {
+		query  string
+		expect []string
+		batch  bool
+	}{
+		{"principal=a", []string{"a"}, false},
+		{"principal=a,b", []string{"a", "b"}, true},
+		{"principal=a&principal=b", []string{"a", "b"}, true},
+		// The result is a batch if batch syntax was used even if only one
+		// principal remains
+		{"principal=a,a", []string{"a"}, true},
+		{"principal=a&principal=a", []string{"a"}, true},
+	}
+
+	for _, v := range vectors {
+
+		app := &testApp{}
+		server := &Server{app: app}
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/getkeytab?bearertoken=token&"+v.query, nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s expected status %d, got %d", v.query, http.StatusOK, w.Code)
+		}
+
+		if !reflect.DeepEqual(app.principals, v.expect) {
+			t.Fatalf("%s expected principals %s, got %s", v.query, v.expect, app.principals)
+		}
+
+		var result map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &result)
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+
+		if _, batch := result["keytabs"]; batch != v.batch {
+			t.Fatalf("%s expected batch %t, got %s", v.query, v.batch, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	(&Server{app: &testApp{}}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/getkeytab?bearertoken=token", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d without principal, got %d", http.StatusConflict, w.Code)
+	}
+
+}
+
+func TestHealth(t *testing.T) {
+
+	server := &Server{app: &testApp{
+		health: []*keytab.Health{
+			&keytab.Health{Principal: "user1@EXAMPLE.COM", State: keytab.HealthOK},
+			&keytab.Health{Principal: "user2@EXAMPLE.COM", State: keytab.HealthFailing, LastError: "kadmin failed"},
+		},
+	}}
+
+	get := func(query string) *health {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		result := &health{}
+		err := json.Unmarshal(w.Body.Bytes(), result)
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+		return result
+	}
+
+	// Without the peer token only the aggregate is returned
+	for _, query := range []string{"", "?bearertoken=wrong"} {
+		result := get(query)
+		if result.Status != keytab.HealthFailing || result.OK != 1 || result.Failing != 1 {
+			t.Fatalf("Unexpected health %s", result.JSON())
+		}
+		if result.Keytabs != nil || result.Peers != nil {
+			t.Fatalf("Unexpected detail without peer token %s", result.JSON())
+		}
+	}
+
+	result := get("?bearertoken=peer")
+	if len(result.Keytabs) != 2 || result.Keytabs[1].LastError != "kadmin failed" {
+		t.Fatalf("Expected detail with peer token, got %s", result.JSON())
+	}
+
+}
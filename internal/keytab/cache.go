@@ -17,20 +17,24 @@ limitations under the License.
 package keytab
 
 import (
-	"bufio"
-	"bytes"
+	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/base32"
-	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"os/exec"
+	"math/rand"
+	"path"
 	"regexp"
-	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
+	"github.com/jodydadescott/tokens2secrets/internal/event"
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
+	"github.com/jodydadescott/tokens2secrets/internal/peer"
+	"github.com/jodydadescott/tokens2secrets/internal/seed"
 	"github.com/jodydadescott/tokens2secrets/internal/timeperiod"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
@@ -40,11 +44,16 @@ import (
 var (
 	principalRegex  = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 	defaultLifetime = time.Duration(5) * time.Minute
+	defaultIdle     = time.Duration(1) * time.Hour
+	minRetryDelay   = time.Duration(5) * time.Second
+	maxRetryDelay   = time.Duration(5) * time.Minute
 
 	passwordCharset = "abcdefghijklmnopqrstuvwxyz" +
 		"ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@!"
 )
 
+const maxKvno = 255
+
 // Config Configuration
 //
 // Seed: A shared secret that the password for a keytab is generated from
@@ -52,8 +61,39 @@ var (
 // Principals: Zero or more principlas Kerberos principals (or usernames)
 //
 // TimePeriod: Time Period for Keytab Renewals
+//
+// Generators: Optional Generators by backend name. These are added to (or
+// replace) the default ktpass, kadmin and native Generators. Each Keytab
+// selects its Generator with the Backend field.
+//
+// Keytabs: Zero or more Keytabs. If the Principal of a Keytab contains the
+// wildcards * or ? it is a pattern. Principals that match the pattern are
+// provisioned when first requested with a seed derived from the Seed and the
+// principal. The keytab is not ready until a worker has generated it. They
+// are evicted once idle for Idle
+//
+// Workers: Maximum number of keytabs that are generated at the same time.
+// Default is 8
+//
+// Jitter: Maximum random time before the end of the period that the keytab
+// for the next period is generated. This spreads the load on the KDC when many
+// keytabs are rotated at the top of the period and has the keytab ready at the
+// boundary
+//
+// Events: Optional Publisher for rotation, failure and expiry events
+//
+// MasterKey: Master key for Keytabs with the hkdf-sha256-v1 Derivation
+//
+// Clock: Source of time for rotation, half life and eviction. Default is the
+// system clock
 type Config struct {
-	Keytabs []*Keytab
+	Keytabs    []*Keytab
+	Generators map[string]Generator
+	Workers    int
+	Jitter     time.Duration
+	Events     *event.Publisher
+	MasterKey  *masterkey.Key
+	Clock      clock.Clock
 }
 
 // Cache holds and manages Kerberos Keytabs. Keytabs are generated or
@@ -87,18 +127,50 @@ type Config struct {
 type Cache struct {
 	closeTimer chan struct{}
 	wg         sync.WaitGroup
-	ticker     *time.Ticker
+	ticker     clock.Ticker
+	clock      clock.Clock
 	mutex      sync.RWMutex
 	internal   map[string]*wrapper
+	patterns   []*Keytab
+	generators map[string]Generator
+	queue      chan *job
+	jitter     time.Duration
+	events     *event.Publisher
+	masterKey  *masterkey.Key
 }
 
 type wrapper struct {
-	mutex           sync.RWMutex
-	nextUpdate      time.Time
-	principal, seed string
-	keytab          *Keytab
-	err             error
-	timePeriod      *timeperiod.TimePeriod
+	lastAccess    int64 // accessed atomically; first for 64 bit alignment
+	queued        int32 // accessed atomically
+	idle          time.Duration
+	mutex         sync.RWMutex
+	nextUpdate    time.Time
+	dueAt         time.Time
+	jitter        time.Duration
+	principal     string
+	seeds         *seed.Seeds
+	keytab        *Keytab
+	err           error
+	timePeriod    *timeperiod.TimePeriod
+	generator     Generator
+	enctypes      []string
+	kvno          uint32
+	rotateKvno    bool
+	principalType string
+	spns          []string
+	next          *Keytab
+	expiring      []*event.Event
+	failures      int
+	failedEpoch   int64
+	retryAt       time.Time
+	lastSuccess   time.Time
+	lastFailure   time.Time
+	lastError     string
+	queueTime     time.Duration
+	runTime       time.Duration
+	events        *event.Publisher
+	derivation    string
+	masterKey     *masterkey.Key
 }
 
 // Build Returns new instance of Keytabs
@@ -106,11 +178,20 @@ func (config *Config) Build() (*Cache, error) {
 
 	zap.L().Debug("Starting")
 
+	timeSource := clock.Real()
+	if config.Clock != nil {
+		timeSource = config.Clock
+	}
+
 	t := &Cache{
 		closeTimer: make(chan struct{}),
 		wg:         sync.WaitGroup{},
-		ticker:     time.NewTicker(time.Second),
+		ticker:     timeSource.NewTicker(time.Second),
+		clock:      timeSource,
 		internal:   make(map[string]*wrapper),
+		jitter:     config.Jitter,
+		events:     config.Events,
+		masterKey:  config.MasterKey,
 	}
 
 	err := t.init(config)
@@ -118,6 +199,22 @@ func (config *Config) Build() (*Cache, error) {
 		return nil, err
 	}
 
+	if config.Workers < 0 {
+		return nil, fmt.Errorf("Workers must be 0 or greater")
+	}
+
+	if config.Jitter < 0 {
+		return nil, fmt.Errorf("Jitter must be 0 or greater")
+	}
+
+	workers := defaultWorkers
+	if config.Workers > 0 {
+		workers = config.Workers
+	}
+
+	t.queue = make(chan *job, queueSize)
+	t.startWorkers(workers)
+
 	go t.run()
 	return t, nil
 }
@@ -127,43 +224,253 @@ func (t *Cache) init(config *Config) error {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
+	generators := defaultGenerators()
+	for name, generator := range config.Generators {
+		if generator == nil {
+			return fmt.Errorf("Generator %s is nil", name)
+		}
+		generators[name] = generator
+	}
+	t.generators = generators
+
 	for _, keytab := range config.Keytabs {
-		if len(keytab.Principal) < 3 && len(keytab.Principal) > 254 {
-			if len(keytab.Principal) < 3 {
-				return fmt.Errorf("Keytab principal %s is to short", keytab.Principal)
+
+		if isPattern(keytab.Principal) {
+
+			if _, err := path.Match(keytab.Principal, ""); err != nil {
+				return fmt.Errorf("Keytab pattern %s is invalid; %s", keytab.Principal, err.Error())
 			}
-			return fmt.Errorf("Keytab principal %s is to long", keytab.Principal)
-		}
 
-		if !principalRegex.MatchString(keytab.Principal) {
-			return fmt.Errorf("Keytab principal %s is invalid", keytab.Principal)
+			// Validate the rest of the keytab with an example principal
+			// so that errors are found at start and not at first request
+			example := keytab.Copy()
+			example.Principal = strings.NewReplacer("*", "x", "?", "x").Replace(keytab.Principal)
+			if _, err := t.newWrapper(example); err != nil {
+				return err
+			}
+
+			t.patterns = append(t.patterns, keytab.Copy())
+			zap.L().Debug(fmt.Sprintf("Loaded principal pattern %s", keytab.Principal))
+			continue
 		}
 
-		if keytab.Seed == "" {
-			return fmt.Errorf("Keytab %s is missing required seed", keytab.Principal)
+		wrapper, err := t.newWrapper(keytab)
+		if err != nil {
+			return err
 		}
 
-		seed := base32.StdEncoding.EncodeToString([]byte(keytab.Seed))
+		t.internal[keytab.Principal] = wrapper
+	}
 
-		lifetime := defaultLifetime
-		if keytab.Lifetime > 0 {
-			lifetime = keytab.Lifetime
-		}
+	return nil
+}
+
+// newWrapper validates the keytab and returns a new wrapper for it
+func (t *Cache) newWrapper(keytab *Keytab) (*wrapper, error) {
 
-		// Lifetime less then a minute requires to much resources and does not make much sense
-		if lifetime < time.Minute {
-			return fmt.Errorf(fmt.Sprintf("Keytab %s lifetime is less then one minute. Lifetime must be one minute or greater", keytab.Principal))
+	if len(keytab.Principal) < 3 && len(keytab.Principal) > 254 {
+		if len(keytab.Principal) < 3 {
+			return nil, fmt.Errorf("Keytab principal %s is to short", keytab.Principal)
 		}
+		return nil, fmt.Errorf("Keytab principal %s is to long", keytab.Principal)
+	}
+
+	if !principalRegex.MatchString(keytab.Principal) {
+		return nil, fmt.Errorf("Keytab principal %s is invalid", keytab.Principal)
+	}
+
+	err := masterkey.ValidateDerivation(keytab.Derivation)
+	if err != nil {
+		return nil, fmt.Errorf("Keytab %s is invalid; %s", keytab.Principal, err.Error())
+	}
+
+	derivation := masterkey.DerivationSeed
+	if keytab.Derivation != "" {
+		derivation = keytab.Derivation
+	}
+
+	if derivation == masterkey.DerivationSeed && keytab.Seed == "" && len(keytab.Seeds) == 0 {
+		return nil, fmt.Errorf("Keytab %s is missing required seed", keytab.Principal)
+	}
+
+	if derivation != masterkey.DerivationSeed && t.masterKey == nil {
+		return nil, fmt.Errorf("Keytab %s derivation %s requires the master key", keytab.Principal, derivation)
+	}
+
+	seeds, err := seed.New(keytab.Seed, keytab.Seeds)
+	if err != nil {
+		return nil, fmt.Errorf("Keytab %s is invalid; %s", keytab.Principal, err.Error())
+	}
+
+	lifetime := defaultLifetime
+	if keytab.Lifetime > 0 {
+		lifetime = keytab.Lifetime
+	}
+
+	timePeriod, err := (&timeperiod.Config{
+		Lifetime: lifetime,
+		Schedule: keytab.Schedule,
+		Offset:   keytab.Offset,
+		Stagger:  keytab.Stagger,
+	}).Build(keytab.Principal)
+	if err != nil {
+		return nil, fmt.Errorf("Keytab %s is invalid; %s", keytab.Principal, err.Error())
+	}
+
+	// The kvno is derived from the index of the period which only increases
+	// by one each period for a fixed lifetime
+	if keytab.RotateKvno && timePeriod.IsSchedule() {
+		return nil, fmt.Errorf("Keytab %s rotateKvno is not supported with a cron or calendar schedule", keytab.Principal)
+	}
+
+	// Lifetime less then a minute requires to much resources and does not make much sense.
+	// Schedules can not have periods of less then a minute.
+	if timePeriod.Duration > 0 && timePeriod.Duration < time.Minute {
+		return nil, fmt.Errorf(fmt.Sprintf("Keytab %s lifetime is less then one minute. Lifetime must be one minute or greater", keytab.Principal))
+	}
+
+	backend := defaultBackend()
+	if keytab.Backend != "" {
+		backend = keytab.Backend
+	}
+
+	generator, exist := t.generators[backend]
+	if !exist {
+		return nil, fmt.Errorf("Keytab %s backend %s is unknown", keytab.Principal, backend)
+	}
+
+	enctypes, err := normalizeEnctypes(keytab.Enctypes)
+	if err != nil {
+		return nil, fmt.Errorf("Keytab %s is invalid; %s", keytab.Principal, err.Error())
+	}
 
-		t.internal[keytab.Principal] = &wrapper{
-			principal:  keytab.Principal,
-			timePeriod: timeperiod.NewPeriod(lifetime),
-			seed:       seed,
+	principalType, err := normalizePrincipalType(keytab.PrincipalType)
+	if err != nil {
+		return nil, fmt.Errorf("Keytab %s is invalid; %s", keytab.Principal, err.Error())
+	}
+
+	spns, err := expandSPNs(keytab.Principal, keytab.Service, keytab.Host, keytab.SPNs)
+	if err != nil {
+		return nil, fmt.Errorf("Keytab %s is invalid; %s", keytab.Principal, err.Error())
+	}
+
+	if keytab.Kvno < 0 || keytab.Kvno > maxKvno {
+		return nil, fmt.Errorf("Keytab %s kvno must be between 1 and %d or 0 for the default", keytab.Principal, maxKvno)
+	}
+
+	kvno := uint32(1)
+	if keytab.Kvno > 0 {
+		kvno = uint32(keytab.Kvno)
+	}
+
+	zap.L().Debug(fmt.Sprintf("Loaded principal %s with backend %s", keytab.Principal, backend))
+
+	return &wrapper{
+		principal:     keytab.Principal,
+		timePeriod:    timePeriod,
+		seeds:         seeds,
+		generator:     generator,
+		enctypes:      enctypes,
+		kvno:          kvno,
+		rotateKvno:    keytab.RotateKvno,
+		principalType: principalType,
+		spns:          spns,
+		events:        t.events,
+		jitter:        t.jitter,
+		derivation:    derivation,
+		masterKey:     t.masterKey,
+	}, nil
+}
+
+// provision creates the wrapper for a principal that matches a pattern. The
+// seed of the principal is derived from the seed of the pattern and the
+// principal so that every instance of the server derives the same password.
+// The keytab is generated by the workers like any other so that a burst of
+// new principals is bounded by the worker limit. It is pending until then.
+func (t *Cache) provision(principal string) (*wrapper, error) {
+
+	t.mutex.Lock()
+
+	if wrapper, exist := t.internal[principal]; exist {
+		t.mutex.Unlock()
+		return wrapper, nil
+	}
+
+	var pattern *Keytab
+	for _, p := range t.patterns {
+		if matched, _ := path.Match(p.Principal, principal); matched {
+			pattern = p
+			break
 		}
-		zap.L().Debug(fmt.Sprintf("Loaded principal %s", keytab.Principal))
 	}
 
-	return nil
+	if pattern == nil {
+		t.mutex.Unlock()
+		zap.L().Debug(fmt.Sprintf("Keytab %s does not exist", principal))
+		return nil, ErrNotFound
+	}
+
+	keytab := pattern.Copy()
+	keytab.Principal = principal
+	if pattern.Seed != "" {
+		keytab.Seed = deriveSeed(pattern.Seed, principal)
+	}
+
+	keytab.Seeds = nil
+	for _, s := range pattern.Seeds {
+		keytab.Seeds = append(keytab.Seeds, &SeedVersion{
+			Seed:       deriveSeed(s.Seed, principal),
+			Activation: s.Activation,
+		})
+	}
+
+	wrapper, err := t.newWrapper(keytab)
+	if err != nil {
+		t.mutex.Unlock()
+		zap.L().Debug(fmt.Sprintf("Keytab %s matches pattern %s but is invalid; err->%s", principal, pattern.Principal, err.Error()))
+		return nil, ErrNotFound
+	}
+
+	idle := defaultIdle
+	if pattern.Idle > 0 {
+		idle = pattern.Idle
+	}
+
+	wrapper.idle = idle
+	wrapper.lastAccess = t.getTime().Unix()
+	t.internal[principal] = wrapper
+	t.mutex.Unlock()
+
+	zap.L().Debug(fmt.Sprintf("Provisioned principal %s from pattern %s", principal, pattern.Principal))
+
+	t.enqueue(wrapper)
+	return wrapper, nil
+}
+
+// deriveSeed returns the seed for a principal provisioned from a pattern
+func deriveSeed(seed, principal string) string {
+	mac := hmac.New(sha256.New, []byte(seed))
+	mac.Write([]byte(principal))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func isPattern(principal string) bool {
+	return strings.ContainsAny(principal, "*?")
+}
+
+// evict removes the provisioned principals that have been idle
+func (t *Cache) evict(now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for principal, wrapper := range t.internal {
+		if wrapper.idle <= 0 {
+			continue
+		}
+		if now.Unix()-atomic.LoadInt64(&wrapper.lastAccess) > int64(wrapper.idle.Seconds()) {
+			zap.L().Debug(fmt.Sprintf("Evicting idle principal %s", principal))
+			delete(t.internal, principal)
+		}
+	}
 }
 
 func (t *Cache) run() {
@@ -173,37 +480,72 @@ func (t *Cache) run() {
 
 	timeperiod := timeperiod.NewPeriod(time.Minute)
 
-	next := timeperiod.From(getTime()).Next().Time()
+	next := timeperiod.From(t.getTime()).Next().Time()
 
-	// We need to run on the top of the time period (or as close as possible). Based on now we
-	// set the next run to be the top of the next minute. Technically this means there can be a
-	// 59 second delay before the first run based on the time the server is started. We run a
-	// ticker every second and check to see if now is equal to or greater then next and if it
-	// is we run our jobs and update the next
+	// We run a ticker every second and queue the keytabs that are due. Each keytab is checked
+	// against its own period as an offset may put the end of a period between the top of two
+	// minutes. Queuing does not block so a full queue does not hold up the loop. Keytabs that
+	// did not fit remain due and are queued on a later tick. Idle principals are evicted at the
+	// top of each minute.
 
 	for {
 		select {
 		case <-t.closeTimer:
 			t.wg.Done()
 			return
-		case <-t.ticker.C:
+		case <-t.ticker.C():
 			// This fires every second
-			now := getTime()
+			now := t.getTime()
 			if now.Equal(next) || now.After(next) {
-				go t.update(next)
+				t.evict(now)
 				next = timeperiod.From(now).Next().Time()
 			}
+			t.updateDue(now)
 		}
 	}
 
 }
 
-func (t *Cache) update(now time.Time) {
+// updateDue publishes the expiry of the keytabs whose period has ended and
+// queues the keytabs that have not been generated, that failed and are due for
+// a retry or that are within the jitter of the end of their period
+func (t *Cache) updateDue(now time.Time) {
+	for _, wrapper := range t.wrappers() {
+		wrapper.expire(now)
+		if wrapper.due(now) {
+			t.enqueue(wrapper)
+		}
+	}
+}
+
+// GetHealth Returns the health of each keytab
+func (t *Cache) GetHealth() []*Health {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
+	var result []*Health
 	for _, wrapper := range t.internal {
-		go wrapper.update(now)
+		result = append(result, wrapper.health())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Principal < result[j].Principal
+	})
+	return result
+}
+
+// GetFingerprints Returns the fingerprint of the password of each keytab for
+// the period of now so that it may be compared with other instances
+func (t *Cache) GetFingerprints(now time.Time, key []byte) []*peer.Fingerprint {
+	var result []*peer.Fingerprint
+	for _, wrapper := range t.wrappers() {
+		period := wrapper.timePeriod.From(now)
+		password, err := wrapper.getPassword(period)
+		if err != nil {
+			zap.L().Error(fmt.Sprintf("Unable to get fingerprint for keytab %s; err->%s", wrapper.principal, err.Error()))
+			continue
+		}
+		result = append(result, peer.NewFingerprint(key, event.KindKeytab, wrapper.principal, period.Epoch, password))
 	}
+	return result
 }
 
 func (t *wrapper) update(now time.Time) {
@@ -211,42 +553,44 @@ func (t *wrapper) update(now time.Time) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	if now.Equal(t.nextUpdate) || now.After(t.nextUpdate) {
+	// Within the jitter before the end of the period the keytab is generated
+	// for the next period
+	at := now
+	if now.Before(t.nextUpdate) && !now.Before(t.dueAt) {
+		at = t.nextUpdate
+	}
 
-		zap.L().Debug(fmt.Sprintf("Keytab %s ready for new keytab", t.principal))
+	if at.Equal(t.nextUpdate) || at.After(t.nextUpdate) {
 
-		nowPeriod := t.timePeriod.From(now)
-		now = nowPeriod.Time()
+		zap.L().Debug(fmt.Sprintf("Keytab %s ready for new keytab", t.principal))
 
-		otp, err := totp.GenerateCodeCustom(t.seed, now, totp.ValidateOpts{
-			Period:    30,
-			Skew:      1,
-			Digits:    otp.DigitsEight,
-			Algorithm: otp.AlgorithmSHA512,
-		})
+		nowPeriod := t.timePeriod.From(at)
 
-		if err != nil {
-			zap.L().Error(fmt.Sprintf("Unable to get create keytab %s ; err->%s", t.principal, err.Error()))
-			t.err = err
-			t.keytab = nil
+		// After a failure the keytab is retried with backoff until it succeeds
+		// or the next period starts
+		if t.failures > 0 && t.failedEpoch == nowPeriod.Epoch && now.Before(t.retryAt) {
+			zap.L().Debug(fmt.Sprintf("Keytab %s failed %d times; next retry at %s", t.principal, t.failures, t.retryAt))
 			return
 		}
 
-		hash := sha256.Sum256([]byte(otp + t.seed))
-
-		b := make([]byte, 28)
-		for i := range b {
-			b[i] = getChar(hash[i])
+		password, err := t.getPassword(nowPeriod)
+		if err != nil {
+			t.fail(err, nowPeriod, now)
+			return
 		}
 
-		password := string(b)
-
-		base64File, err := newKeytab(t.principal, password)
+		base64File, err := t.generator.NewKeytab(&Spec{
+			Principal:     t.principal,
+			SPNs:          t.spns,
+			Password:      password,
+			Enctypes:      t.enctypes,
+			Kvno:          t.getKvno(nowPeriod),
+			PrincipalType: t.principalType,
+			Time:          nowPeriod.Time(),
+		})
 
 		if err != nil {
-			zap.L().Error(fmt.Sprintf("Unable to get create keytab %s ; err->%s", t.principal, err.Error()))
-			t.err = err
-			t.keytab = nil
+			t.fail(err, nowPeriod, now)
 			return
 		}
 
@@ -255,14 +599,38 @@ func (t *wrapper) update(now time.Time) {
 		passwordhash := fmt.Sprintf("%x", sha256.Sum256([]byte(password)))[:12]
 
 		t.nextUpdate = nowPeriod.Next().Time()
+		t.dueAt = t.nextUpdate.Add(-t.getJitter(nowPeriod))
 		t.err = nil
+		t.failures = 0
+		t.retryAt = time.Time{}
+		t.lastSuccess = now
 		t.keytab = &Keytab{
-			Principal:  "HTTP/" + t.principal,
+			Principal:  t.spns[0],
+			Principals: t.spns,
 			Base64File: base64File,
-			Exp:        nowPeriod.Time().Unix() + int64(t.timePeriod.Duration.Seconds()),
+			Exp:        nowPeriod.End().Unix(),
+			Kvno:       int(t.getKvno(nowPeriod)),
 		}
 
 		zap.L().Debug(fmt.Sprintf("Keytab %s generated; password=%s, exp=%d", t.principal, passwordhash, t.keytab.Exp))
+
+		t.expiring = append(t.expiring, &event.Event{
+			Type: event.TypeExpired,
+			Kind: event.KindKeytab,
+			Name: t.principal,
+			Exp:  t.keytab.Exp,
+			Kvno: t.keytab.Kvno,
+		})
+
+		t.next = t.getNextKeytab(nowPeriod.Next())
+
+		t.events.Publish(&event.Event{
+			Type: event.TypeRotated,
+			Kind: event.KindKeytab,
+			Name: t.principal,
+			Exp:  t.keytab.Exp,
+			Kvno: t.keytab.Kvno,
+		})
 		return
 
 	}
@@ -271,6 +639,228 @@ func (t *wrapper) update(now time.Time) {
 
 }
 
+// getJitter returns a random time of up to the jitter before the end of the
+// period. It is at most half of the period so that the keytab of the period is
+// not replaced as soon as it is generated.
+func (t *wrapper) getJitter(period *timeperiod.TimePeriod) time.Duration {
+	jitter := t.jitter
+	if half := period.End().Sub(period.Time()) / 2; jitter > half {
+		jitter = half
+	}
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(jitter)))
+}
+
+// fail records the failure to generate the keytab and schedules the retry.
+// The delay doubles with each consecutive failure up to maxRetryDelay. The
+// current keytab is kept as the failure may be of the early generation for
+// the next period. It is removed by expire once its period ends.
+func (t *wrapper) fail(err error, period *timeperiod.TimePeriod, now time.Time) {
+
+	t.err = err
+
+	if t.failedEpoch != period.Epoch {
+		t.failures = 0
+	}
+
+	t.failures++
+	t.failedEpoch = period.Epoch
+	t.lastError = err.Error()
+	t.lastFailure = now
+
+	delay := minRetryDelay
+	for i := 1; i < t.failures && delay < maxRetryDelay; i++ {
+		delay = delay * 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	t.retryAt = now.Add(delay)
+
+	zap.L().Error(fmt.Sprintf("Unable to get create keytab %s ; failures->%d, retry->%s, err->%s", t.principal, t.failures, t.retryAt, err.Error()))
+
+	t.events.Publish(&event.Event{
+		Type:  event.TypeFailed,
+		Kind:  event.KindKeytab,
+		Name:  t.principal,
+		Error: err.Error(),
+	})
+}
+
+// expire publishes an expired event for each keytab whose period has ended.
+// If the keytab that is served has expired because the keytab for the next
+// period could not be generated it is removed and clients are left without
+// one until the retry succeeds.
+func (t *wrapper) expire(now time.Time) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var expiring []*event.Event
+	for _, e := range t.expiring {
+		if e.Exp > now.Unix() {
+			expiring = append(expiring, e)
+			continue
+		}
+		zap.L().Debug(fmt.Sprintf("Keytab %s kvno %d expired", t.principal, e.Kvno))
+		t.events.Publish(e)
+	}
+	t.expiring = expiring
+
+	if t.keytab != nil && t.keytab.Exp <= now.Unix() {
+		t.keytab = nil
+		t.next = nil
+	}
+}
+
+// due returns true if the keytab has not been generated, if it failed and the
+// retry is due or if it is within the jitter of the end of its period
+func (t *wrapper) due(now time.Time) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if t.failures > 0 {
+		return !now.Before(t.retryAt)
+	}
+	return !now.Before(t.dueAt)
+}
+
+// health returns the health of the keytab
+func (t *wrapper) health() *Health {
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	health := &Health{
+		Principal: t.principal,
+		State:     HealthPending,
+		Failures:  t.failures,
+		LastError: t.lastError,
+		QueueTime: t.queueTime,
+		RunTime:   t.runTime,
+	}
+
+	if !t.lastSuccess.IsZero() {
+		health.LastSuccess = t.lastSuccess.Unix()
+	}
+
+	if !t.lastFailure.IsZero() {
+		health.LastFailure = t.lastFailure.Unix()
+	}
+
+	if t.keytab != nil {
+		health.State = HealthOK
+	}
+
+	if t.failures > 0 {
+		health.State = HealthFailing
+		health.NextRetry = t.retryAt.Unix()
+	}
+
+	return health
+}
+
+// getNextKeytab returns the keytab for the provided (next) period. The keys
+// are derived locally with the Native generator so that the password on the
+// KDC is not changed before the period starts. Clients that receive the next
+// keytab before the rotation can switch over as soon as the rotation happens.
+// As this is only an aid for the client an error is logged and nil returned.
+// Only the Native backend writes the kvno from the Spec and the MIT salt.
+// With kadmin and ktpass the KDC sets the kvno (and for AD the salt) when the
+// password is changed so the next keytab can not be known in advance and nil
+// is returned.
+func (t *wrapper) getNextKeytab(period *timeperiod.TimePeriod) *Keytab {
+
+	native, ok := t.generator.(*Native)
+	if !ok {
+		return nil
+	}
+
+	password, err := t.getPassword(period)
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("Unable to create next keytab %s ; err->%s", t.principal, err.Error()))
+		return nil
+	}
+
+	base64File, err := native.NewKeytab(&Spec{
+		Principal:     t.principal,
+		SPNs:          t.spns,
+		Password:      password,
+		Enctypes:      t.enctypes,
+		Kvno:          t.getKvno(period),
+		PrincipalType: t.principalType,
+		Time:          period.Time(),
+	})
+
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("Unable to create next keytab %s ; err->%s", t.principal, err.Error()))
+		return nil
+	}
+
+	return &Keytab{
+		Base64File: base64File,
+		Exp:        period.End().Unix(),
+		Kvno:       int(t.getKvno(period)),
+	}
+}
+
+// getPassword returns the password for the period. The password is derived
+// from the seed and the time of the period so that every instance of the
+// server with the same seed derives the same password. With the HKDF
+// derivation it is derived from the master key, the principal and the index
+// of the period instead.
+func (t *wrapper) getPassword(period *timeperiod.TimePeriod) (string, error) {
+
+	if t.derivation == masterkey.DerivationHKDFSHA256v1 {
+		index := period.Index()
+		hash, err := t.masterKey.Derive(event.KindKeytab, t.principal, index, 28)
+		if err != nil {
+			return "", err
+		}
+		b := make([]byte, 28)
+		for i := range b {
+			b[i] = getChar(hash[i])
+		}
+		return string(b), nil
+	}
+
+	current := t.seeds.For(period.Time())
+
+	otp, err := totp.GenerateCodeCustom(current, period.Time(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsEight,
+		Algorithm: otp.AlgorithmSHA512,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256([]byte(otp + current))
+
+	b := make([]byte, 28)
+	for i := range b {
+		b[i] = getChar(hash[i])
+	}
+
+	return string(b), nil
+}
+
+// getKvno returns the kvno for the period. If the kvno is rotated it is
+// incremented once per period starting from the configured kvno. It is based
+// on the number of periods since the epoch so that every instance computes the
+// same kvno. The kvno wraps before it exceeds what fits in the 8 bit kvno
+// field of the keytab as some clients only read that field.
+func (t *wrapper) getKvno(period *timeperiod.TimePeriod) uint32 {
+	if !t.rotateKvno {
+		return t.kvno
+	}
+	index := period.Index()
+	return uint32((int64(t.kvno)-1+index)%maxKvno) + 1
+}
+
 func getChar(b byte) byte {
 	bint := int(b)
 	charsetlen := len(passwordCharset)
@@ -296,7 +886,9 @@ func int31n(n int, input int64) int32 {
 	return int32(prod >> 32)
 }
 
-// GetKeytab Returns Keytab if keytab exist.
+// GetKeytab Returns Keytab if keytab exist. If the principal does not exist
+// but matches a pattern it is provisioned and ErrNotReady is returned until
+// the keytab is generated.
 func (t *Cache) GetKeytab(principal string) (*Keytab, error) {
 
 	if principal == "" {
@@ -305,148 +897,52 @@ func (t *Cache) GetKeytab(principal string) (*Keytab, error) {
 	}
 
 	t.mutex.RLock()
-	defer t.mutex.RUnlock()
+	wrapper, exist := t.internal[principal]
+	t.mutex.RUnlock()
 
-	if wrapper, exist := t.internal[principal]; exist {
-
-		wrapper.mutex.RLock()
-		defer wrapper.mutex.RUnlock()
-
-		// Export function; returning copy
-		if wrapper.keytab == nil {
-			if wrapper.err == nil {
-				zap.L().Debug(fmt.Sprintf("Keytab %s has not been processed yet", principal))
-				return nil, ErrNotReady
-			}
-			zap.L().Debug(fmt.Sprintf("Keytab %s not generated due to error; err->%s", principal, wrapper.err.Error()))
-			return nil, ErrGenFail
+	if !exist {
+		var err error
+		wrapper, err = t.provision(principal)
+		if err != nil {
+			return nil, err
 		}
-
-		return wrapper.keytab.Copy(), nil
 	}
 
-	zap.L().Debug(fmt.Sprintf("Keytab %s does not exist", principal))
-	return nil, ErrNotFound
-}
-
-func newKeytab(principal, password string) (string, error) {
-	if runtime.GOOS == "windows" {
-		return windowsNewKeytab(principal, password)
-	}
-	return unixNewKeytab(principal, password)
-}
+	atomic.StoreInt64(&wrapper.lastAccess, t.getTime().Unix())
 
-// Windows Kerberos Implementation (Active Directory) allows for the creation
-// of principals that are mapped to a user account. Only one principal may be
-// mapped to a user account at a time. Once a keytab is created it will remain
-// valid until the principal is removed  or the password is changed or a new
-// keytab is created. The windows utility ktpass is used to create the keytabs.
-// The ktpass command is executed directly on the host. Therefore this should
-// be ran on a Windows system that is a member of the target domain. It must
-// also be ran with privileges to allow the creation of keytabs. Generally this
-// is a Domain Admin. If running as a service it is necessary that it be
-// configured to run as a domain admin or user with the privileges necessary
-// to create keytabs.
-//
-// Information about the ktpass utility is as follows
-// Exe: C:\Windows\System32\ktpass
-// Documentation: https://docs.microsoft.com/en-us/previous-versions/windows/it-pro/windows-server-2012-r2-and-2012/cc753771(v=ws.11)
-// [/out <FileName>]
-// [/princ <PrincipalName>]
-// [/mapuser <UserAccount>]
-// [/mapop {add|set}] [{-|+}desonly] [/in <FileName>]
-// [/pass {Password|*|{-|+}rndpass}]
-// [/minpass]
-// [/maxpass]
-// [/crypto {DES-CBC-CRC|DES-CBC-MD5|RC4-HMAC-NT|AES256-SHA1|AES128-SHA1|All}]
-// [/itercount]
-// [/ptype {KRB5_NT_PRINCIPAL|KRB5_NT_SRV_INST|KRB5_NT_SRV_HST}]
-// [/kvno <KeyVersionNum>]
-// [/answer {-|+}]
-// [/target]
-// [/rawsalt] [{-|+}dumpsalt] [{-|+}setupn] [{-|+}setpass <Password>]  [/?|/h|/help]
-//
-// Use +DumpSalt to dump MIT Salt to output
-//
-// Notes about ktpass failure functionality
-// Testing on Windows Server 2019 reveals that if the user lacks the
-// privileges to create keytabs the ktpass utility does not create the
-// keytab but also still exits with 0 and nothing is sent to the stdout
-// This was with a service account and stderr was not checked. For this
-// reason we will return an auth err if the file does not exist. This
-// should be refined in the future.
-//
-// ktpass -mapUser bob@EXAMPLE.COM -pass ** -mapOp set -crypto AES256-SHA1 -ptype KRB5_NT_PRINCIPAL -princ HTTP/bob@EXAMPLE.COM -out keytab
-//
-func windowsNewKeytab(principal, password string) (string, error) {
+	wrapper.mutex.RLock()
+	defer wrapper.mutex.RUnlock()
 
-	dir, err := ioutil.TempDir("", "kt")
-	if err != nil {
-		return "", err
-	}
-	defer os.RemoveAll(dir)
-
-	filename := dir + `\file.keytab`
-
-	exe := "C:\\Windows\\System32\\ktpass"
-	args := []string{}
-
-	args = append(args, "-mapUser")
-	args = append(args, principal)
-	args = append(args, "-pass")
-	args = append(args, password)
-	args = append(args, "-mapOp")
-	args = append(args, "set")
-	args = append(args, "-crypto")
-	args = append(args, "AES256-SHA1")
-	args = append(args, "-ptype")
-	args = append(args, "KRB5_NT_PRINCIPAL")
-	args = append(args, "-princ")
-	args = append(args, "HTTP/"+principal)
-	args = append(args, "-kvno")
-	args = append(args, "1")
-	args = append(args, "-out")
-	args = append(args, filename)
-
-	logarg := exe
-	for _, arg := range args {
-		logarg = logarg + " " + arg
-	}
-
-	//zap.L().Debug(fmt.Sprintf("command->%s", logarg))
-
-	cmd := exec.Command(exe, args...)
-	cmdOutput := &bytes.Buffer{}
-	cmd.Stdout = cmdOutput
-	err = cmd.Run()
-	if err != nil {
-		zap.L().Error(fmt.Sprintf("exec.Command(%s, %s)", exe, args))
-		return "", err
-	}
-
-	zap.L().Debug(fmt.Sprintf("command->%s, output->%s", logarg, string(cmdOutput.Bytes())))
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return "", err
+	// Export function; returning copy
+	if wrapper.keytab == nil {
+		if wrapper.err == nil {
+			zap.L().Debug(fmt.Sprintf("Keytab %s has not been processed yet", principal))
+			return nil, ErrNotReady
+		}
+		zap.L().Debug(fmt.Sprintf("Keytab %s not generated due to error; err->%s", principal, wrapper.err.Error()))
+		return nil, ErrGenFail
 	}
 
-	reader := bufio.NewReader(f)
-	content, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return "", err
+	result := wrapper.keytab.Copy()
+
+	// Once the half life of the current keytab is reached the keytab for
+	// the next period is included so that the client is able to switch
+	// over without a failed kinit at the rotation
+	now := t.getTime()
+	if wrapper.next != nil && wrapper.next.Exp > result.Exp {
+		if wrapper.timePeriod.From(now).HalfLife(now) {
+			result.NextBase64File = wrapper.next.Base64File
+			result.NextExp = wrapper.next.Exp
+			result.NextKvno = wrapper.next.Kvno
+		}
 	}
 
-	return base64.StdEncoding.EncodeToString(content), nil
-}
-
-func unixNewKeytab(principal, password string) (string, error) {
-	return "this is not a valid keytab, it is fake", nil
+	return result, nil
 }
 
-func getTime() time.Time {
+func (t *Cache) getTime() time.Time {
 	// If running multiple instance the time must be the same so we statically use UTC
-	return time.Now().In(time.UTC)
+	return t.clock.Now().In(time.UTC)
 }
 
 // Shutdown shutdown
@@ -454,4 +950,5 @@ func (t *Cache) Shutdown() {
 	zap.L().Info(fmt.Sprintf("Stopping"))
 	close(t.closeTimer)
 	t.wg.Wait()
+	t.ticker.Stop()
 }
@@ -17,7 +17,16 @@ limitations under the License.
 package keytab
 
 import (
+	"fmt"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
+	"github.com/jodydadescott/tokens2secrets/internal/event"
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
+	"github.com/jodydadescott/tokens2secrets/internal/seed"
+	"github.com/jodydadescott/tokens2secrets/internal/timeperiod"
 )
 
 func Test1(t *testing.T) {
@@ -57,3 +66,743 @@ func Test1(t *testing.T) {
 	// }
 
 }
+
+func TestKvno(t *testing.T) {
+
+	period := timeperiod.NewPeriod(time.Hour).From(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC))
+
+	fixed := &wrapper{kvno: 3}
+	if fixed.getKvno(period) != 3 || fixed.getKvno(period.Next()) != 3 {
+		t.Fatalf("Expected fixed kvno to not change")
+	}
+
+	rotating := &wrapper{kvno: 1, rotateKvno: true}
+	kvno := rotating.getKvno(period)
+	next := rotating.getKvno(period.Next())
+
+	if kvno < 1 || kvno > maxKvno {
+		t.Fatalf("Kvno %d out of range", kvno)
+	}
+
+	if next != kvno%maxKvno+1 {
+		t.Fatalf("Expected kvno to increment from %d, got %d", kvno, next)
+	}
+
+}
+
+func TestDerivation(t *testing.T) {
+
+	encoded, _ := masterkey.Generate()
+	key, _ := masterkey.Parse(encoded)
+
+	keytabs := []*Keytab{
+		&Keytab{
+			Principal:  "bob@EXAMPLE.COM",
+			Lifetime:   time.Hour,
+			Backend:    BackendNative,
+			Derivation: masterkey.DerivationHKDFSHA256v1,
+		},
+	}
+
+	if _, err := (&Config{Keytabs: keytabs}).Build(); err == nil {
+		t.Fatalf("Expected error for derivation without master key")
+	}
+
+	cache, err := (&Config{Keytabs: keytabs, MasterKey: key}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	period := timeperiod.NewPeriod(time.Hour).From(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC))
+	bob := cache.internal["bob@EXAMPLE.COM"]
+
+	password, err := bob.getPassword(period)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	next, _ := bob.getPassword(period.Next())
+	if len(password) != 28 || password == next {
+		t.Fatalf("Expected password to change each period")
+	}
+
+	// The same master key derives the same password on every instance
+	other := &wrapper{principal: "bob@EXAMPLE.COM", derivation: masterkey.DerivationHKDFSHA256v1, masterKey: key}
+	if p, _ := other.getPassword(period); p != password {
+		t.Fatalf("Expected same password from same master key")
+	}
+
+	keytabs[0].Derivation = "md5"
+	if _, err := (&Config{Keytabs: keytabs, MasterKey: key}).Build(); err == nil {
+		t.Fatalf("Expected error for unknown derivation")
+	}
+
+}
+
+func TestSeedRollover(t *testing.T) {
+
+	activation := time.Date(2020, 3, 12, 15, 0, 0, 0, time.UTC)
+
+	cache, err := (&Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal: "bob@EXAMPLE.COM",
+				Seed:      "old",
+				Lifetime:  time.Hour,
+				Backend:   BackendNative,
+				Seeds:     []*SeedVersion{&SeedVersion{Seed: "new", Activation: activation}},
+			},
+		},
+	}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	bob := cache.internal["bob@EXAMPLE.COM"]
+	oldSeeds, _ := seed.New("old", nil)
+	newSeeds, _ := seed.New("new", nil)
+	old := &wrapper{seeds: oldSeeds}
+	newer := &wrapper{seeds: newSeeds}
+
+	// The period before the activation uses the old seed and the period that
+	// starts at the activation uses the new seed
+	period := timeperiod.NewPeriod(time.Hour).From(time.Date(2020, 3, 12, 14, 40, 0, 0, time.UTC))
+
+	current, _ := bob.getPassword(period)
+	expected, _ := old.getPassword(period)
+	if current != expected {
+		t.Fatalf("Expected old seed before activation")
+	}
+
+	next, _ := bob.getPassword(period.Next())
+	expected, _ = newer.getPassword(period.Next())
+	if next != expected {
+		t.Fatalf("Expected new seed from activation")
+	}
+
+}
+
+func TestNextKeytab(t *testing.T) {
+
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 5, 0, 0, time.UTC))
+
+	config := &Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal:  "bob@EXAMPLE.COM",
+				Seed:       "nIKSXX9nJU5klguCrzP3d",
+				Lifetime:   time.Hour,
+				Backend:    BackendNative,
+				RotateKvno: true,
+			},
+		},
+		Clock: fake,
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	current := cache.internal["bob@EXAMPLE.COM"]
+	current.update(cache.getTime())
+
+	if current.next == nil {
+		t.Fatalf("Expected next keytab to be generated")
+	}
+
+	if current.next.Exp != current.keytab.Exp+int64(time.Hour.Seconds()) {
+		t.Fatalf("Expected next exp %d, got %d", current.keytab.Exp+int64(time.Hour.Seconds()), current.next.Exp)
+	}
+
+	if current.next.Kvno != current.keytab.Kvno%maxKvno+1 {
+		t.Fatalf("Expected next kvno to increment from %d, got %d", current.keytab.Kvno, current.next.Kvno)
+	}
+
+	if current.next.Base64File == current.keytab.Base64File {
+		t.Fatalf("Expected next keytab to differ from current")
+	}
+
+	// The next keytab is only included in the final half of the period
+	keytab, err := cache.GetKeytab("bob@EXAMPLE.COM")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if keytab.NextBase64File != "" || keytab.NextExp != 0 || keytab.NextKvno != 0 {
+		t.Fatalf("Unexpected next keytab before half life")
+	}
+
+	fake.Set(time.Date(2020, 3, 12, 14, 40, 0, 0, time.UTC))
+
+	keytab, err = cache.GetKeytab("bob@EXAMPLE.COM")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if keytab.NextBase64File != current.next.Base64File || keytab.NextExp != current.next.Exp || keytab.NextKvno != current.next.Kvno {
+		t.Fatalf("Expected next keytab after half life")
+	}
+
+	// The KDC sets the kvno for the other backends so there is no next keytab
+	other := &wrapper{
+		principal: "bob@EXAMPLE.COM",
+		generator: &Kadmin{},
+	}
+
+	if other.getNextKeytab(current.timePeriod.From(cache.getTime()).Next()) != nil {
+		t.Fatalf("Unexpected next keytab for kadmin backend")
+	}
+
+}
+
+func TestPattern(t *testing.T) {
+
+	config := &Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal: "svc-*@EXAMPLE.COM",
+				Seed:      "nIKSXX9nJU5klguCrzP3d",
+				Lifetime:  time.Hour,
+				Backend:   BackendNative,
+				Idle:      time.Minute,
+			},
+		},
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	if _, err := cache.GetKeytab("bob@EXAMPLE.COM"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Provisioned principals are generated by the workers and are pending
+	// until then
+	if _, err := cache.GetKeytab("svc-web@EXAMPLE.COM"); err != ErrNotReady {
+		t.Fatalf("Expected ErrNotReady, got %v", err)
+	}
+
+	waitFor := func(principal string) *Keytab {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			keytab, err := cache.GetKeytab(principal)
+			if err == nil {
+				return keytab
+			}
+			if err != ErrNotReady {
+				t.Fatalf("Unexpected err %s", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Timeout waiting for keytab %s", principal)
+		return nil
+	}
+
+	keytab := waitFor("svc-web@EXAMPLE.COM")
+
+	if keytab.Principal != "HTTP/svc-web@EXAMPLE.COM" {
+		t.Fatalf("Unexpected principal %s", keytab.Principal)
+	}
+
+	cache.GetKeytab("svc-db@EXAMPLE.COM")
+	other := waitFor("svc-db@EXAMPLE.COM")
+
+	web := cache.internal["svc-web@EXAMPLE.COM"]
+	db := cache.internal["svc-db@EXAMPLE.COM"]
+
+	if web.seeds.For(time.Time{}) == db.seeds.For(time.Time{}) || keytab.Base64File == other.Base64File {
+		t.Fatalf("Expected each principal to have its own seed")
+	}
+
+	if deriveSeed("seed", "svc-web@EXAMPLE.COM") != deriveSeed("seed", "svc-web@EXAMPLE.COM") {
+		t.Fatalf("Expected seed derivation to be deterministic")
+	}
+
+	// Only the idle principal is evicted
+	now := cache.getTime()
+	web.lastAccess = now.Add(-2 * time.Minute).Unix()
+	db.lastAccess = now.Unix()
+	cache.evict(now)
+
+	if _, exist := cache.internal["svc-web@EXAMPLE.COM"]; exist {
+		t.Fatalf("Expected idle principal to be evicted")
+	}
+
+	if _, exist := cache.internal["svc-db@EXAMPLE.COM"]; !exist {
+		t.Fatalf("Expected active principal to remain")
+	}
+
+	config.Keytabs[0].Backend = "unknown"
+	if _, err := config.Build(); err == nil {
+		t.Fatalf("Expected error for pattern with unknown backend")
+	}
+
+}
+
+type failingGenerator struct {
+	calls int
+}
+
+func (t *failingGenerator) NewKeytab(spec *Spec) (string, error) {
+	t.calls++
+	return "", fmt.Errorf("failed")
+}
+
+func TestRetry(t *testing.T) {
+
+	generator := &failingGenerator{}
+
+	config := &Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal: "bob@EXAMPLE.COM",
+				Seed:      "nIKSXX9nJU5klguCrzP3d",
+				Lifetime:  time.Hour,
+				Backend:   "failing",
+			},
+		},
+		Generators: map[string]Generator{
+			"failing": generator,
+		},
+		// The clock is not advanced so that the run loop does not queue the
+		// keytab while it is updated by the test
+		Clock: clock.NewFake(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC)),
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	wrapper := cache.internal["bob@EXAMPLE.COM"]
+
+	if cache.GetHealth()[0].State != HealthPending {
+		t.Fatalf("Expected state %s", HealthPending)
+	}
+
+	now := timeperiod.NewPeriod(time.Hour).From(cache.getTime()).Time()
+	wrapper.update(now)
+
+	if _, err := cache.GetKeytab("bob@EXAMPLE.COM"); err != ErrGenFail {
+		t.Fatalf("Expected ErrGenFail, got %v", err)
+	}
+
+	// Not retried until the backoff has passed
+	wrapper.update(now.Add(time.Second))
+	if generator.calls != 1 || wrapper.due(now.Add(time.Second)) {
+		t.Fatalf("Expected no retry before backoff, got %d calls", generator.calls)
+	}
+
+	if !wrapper.due(now.Add(minRetryDelay)) {
+		t.Fatalf("Expected retry to be due")
+	}
+
+	// The backoff doubles with each failure
+	wrapper.update(now.Add(minRetryDelay))
+	if generator.calls != 2 || !wrapper.retryAt.Equal(now.Add(3*minRetryDelay)) {
+		t.Fatalf("Expected backoff to double, got retry at %s", wrapper.retryAt)
+	}
+
+	health := cache.GetHealth()[0]
+	if health.State != HealthFailing || health.Failures != 2 || health.LastError != "failed" {
+		t.Fatalf("Unexpected health %s", health.JSON())
+	}
+
+	// The failures reset in the next period
+	wrapper.update(now.Add(time.Hour))
+	if generator.calls != 3 || wrapper.failures != 1 {
+		t.Fatalf("Expected failures to reset in next period, got %d", wrapper.failures)
+	}
+
+}
+
+type blockingGenerator struct {
+	mutex        sync.Mutex
+	running, max int
+	calls        int
+	release      chan struct{}
+}
+
+func (t *blockingGenerator) NewKeytab(spec *Spec) (string, error) {
+
+	t.mutex.Lock()
+	t.running++
+	t.calls++
+	if t.running > t.max {
+		t.max = t.running
+	}
+	t.mutex.Unlock()
+
+	<-t.release
+
+	t.mutex.Lock()
+	t.running--
+	t.mutex.Unlock()
+
+	return "a2V5dGFi", nil
+}
+
+func TestWorkers(t *testing.T) {
+
+	generator := &blockingGenerator{release: make(chan struct{})}
+
+	config := &Config{
+		Generators: map[string]Generator{
+			"blocking": generator,
+		},
+		Workers: 2,
+	}
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		config.Keytabs = append(config.Keytabs, &Keytab{
+			Principal: name + "@EXAMPLE.COM",
+			Seed:      "nIKSXX9nJU5klguCrzP3d",
+			Lifetime:  time.Hour,
+			Backend:   "blocking",
+		})
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	cache.updateDue(cache.getTime())
+
+	// Release one generation at a time and check that no more then the
+	// configured number of workers run at the same time
+	for i := 0; i < len(config.Keytabs); i++ {
+		select {
+		case generator.release <- struct{}{}:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for worker")
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, health := range cache.GetHealth() {
+		for health.State != HealthOK && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			health = cache.internal[health.Principal].health()
+		}
+		if health.State != HealthOK {
+			t.Fatalf("Expected keytab %s to be generated", health.Principal)
+		}
+	}
+
+	generator.mutex.Lock()
+	defer generator.mutex.Unlock()
+
+	if generator.max > 2 {
+		t.Fatalf("Expected at most 2 concurrent generations, got %d", generator.max)
+	}
+
+	if generator.calls != len(config.Keytabs) {
+		t.Fatalf("Expected %d generations, got %d", len(config.Keytabs), generator.calls)
+	}
+
+	config.Workers = -1
+	if _, err := config.Build(); err == nil {
+		t.Fatalf("Expected error for negative workers")
+	}
+
+}
+
+func TestJitter(t *testing.T) {
+
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC))
+
+	config := &Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal: "bob@EXAMPLE.COM",
+				Seed:      "nIKSXX9nJU5klguCrzP3d",
+				Lifetime:  time.Hour,
+				Backend:   BackendNative,
+			},
+		},
+		Jitter: time.Minute,
+		Clock:  fake,
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	// waitFor advances the clock until the keytab expires at exp and returns
+	// the time it was generated at
+	waitFor := func(exp time.Time) time.Time {
+		for i := 0; i < 120; i++ {
+			fake.Advance(time.Second)
+			deadline := time.Now().Add(250 * time.Millisecond)
+			for time.Now().Before(deadline) {
+				keytab, err := cache.GetKeytab("bob@EXAMPLE.COM")
+				if err == nil && keytab.Exp == exp.Unix() {
+					return fake.Now()
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		t.Fatalf("Timeout waiting for keytab that expires at %s", exp)
+		return time.Time{}
+	}
+
+	// The first keytab is generated without jitter
+	waitFor(time.Date(2020, 3, 12, 15, 0, 0, 0, time.UTC))
+
+	// The next keytab is generated within the jitter before the end of the
+	// period so that it is ready at the boundary
+	fake.Set(time.Date(2020, 3, 12, 14, 58, 30, 0, time.UTC))
+	at := waitFor(time.Date(2020, 3, 12, 16, 0, 0, 0, time.UTC))
+	if at.Before(time.Date(2020, 3, 12, 14, 59, 0, 0, time.UTC)) || at.After(time.Date(2020, 3, 12, 15, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected keytab to be generated within the jitter before the period ends, got %s", at)
+	}
+
+}
+
+type recordingSink struct {
+	mutex  sync.Mutex
+	events []*event.Event
+}
+
+func (t *recordingSink) Send(e *event.Event) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.events = append(t.events, e)
+	return nil
+}
+
+func TestEvents(t *testing.T) {
+
+	sink := &recordingSink{}
+
+	events, err := (&event.Config{Sinks: []event.Sink{sink}}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	generator := &failingGenerator{}
+
+	config := &Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal: "bob@EXAMPLE.COM",
+				Seed:      "nIKSXX9nJU5klguCrzP3d",
+				Lifetime:  time.Hour,
+				Backend:   BackendNative,
+			},
+		},
+		Generators: map[string]Generator{
+			"failing": generator,
+		},
+		Events: events,
+		Clock:  clock.NewFake(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC)),
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	wrapper := cache.internal["bob@EXAMPLE.COM"]
+	now := timeperiod.NewPeriod(time.Hour).From(cache.getTime()).Time()
+	wrapper.update(now)
+
+	// The keytab expires at the end of its period after the rotation
+	wrapper.update(now.Add(time.Hour))
+	wrapper.expire(now.Add(time.Hour))
+
+	if wrapper.keytab == nil {
+		t.Fatalf("Expected rotated keytab to be kept")
+	}
+
+	// The next period fails leaving the client without a keytab once the
+	// keytab expires
+	wrapper.generator = generator
+	wrapper.update(now.Add(2 * time.Hour))
+	wrapper.expire(now.Add(2 * time.Hour))
+
+	if _, err := cache.GetKeytab("bob@EXAMPLE.COM"); err != ErrGenFail {
+		t.Fatalf("Expected ErrGenFail, got %v", err)
+	}
+
+	events.Shutdown()
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	expect := []string{event.TypeRotated, event.TypeRotated, event.TypeExpired, event.TypeFailed, event.TypeExpired}
+	if len(sink.events) != len(expect) {
+		t.Fatalf("Expected %d events, got %d", len(expect), len(sink.events))
+	}
+
+	for i, e := range sink.events {
+		if e.Type != expect[i] || e.Kind != event.KindKeytab || e.Name != "bob@EXAMPLE.COM" {
+			t.Fatalf("Unexpected event %s", e.JSON())
+		}
+	}
+
+	if sink.events[2].Exp != now.Add(time.Hour).Unix() || sink.events[4].Exp != now.Add(2*time.Hour).Unix() {
+		t.Fatalf("Expected expired events for the periods that ended")
+	}
+
+}
+
+func TestFakeClock(t *testing.T) {
+
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 9, 30, 0, time.UTC))
+
+	config := &Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal:  "bob@EXAMPLE.COM",
+				Seed:       "nIKSXX9nJU5klguCrzP3d",
+				Lifetime:   time.Hour,
+				Backend:    BackendNative,
+				RotateKvno: true,
+			},
+		},
+		Clock: fake,
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	// waitFor advances the clock until the keytab expires at exp. The run
+	// loop and the workers are asynchronous so each step is retried.
+	waitFor := func(exp time.Time) *Keytab {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			fake.Advance(time.Second)
+			keytab, err := cache.GetKeytab("bob@EXAMPLE.COM")
+			if err == nil && keytab.Exp == exp.Unix() {
+				return keytab
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Timeout waiting for keytab that expires at %s", exp)
+		return nil
+	}
+
+	if _, err := cache.GetKeytab("bob@EXAMPLE.COM"); err != ErrNotReady {
+		t.Fatalf("Expected ErrNotReady, got %v", err)
+	}
+
+	keytab := waitFor(time.Date(2020, 3, 12, 15, 0, 0, 0, time.UTC))
+	if keytab.NextBase64File != "" {
+		t.Fatalf("Unexpected next keytab before half life")
+	}
+
+	fake.Set(time.Date(2020, 3, 12, 14, 31, 0, 0, time.UTC))
+	keytab, _ = cache.GetKeytab("bob@EXAMPLE.COM")
+	if keytab.NextExp != time.Date(2020, 3, 12, 16, 0, 0, 0, time.UTC).Unix() {
+		t.Fatalf("Expected next keytab after half life")
+	}
+
+	fake.Set(time.Date(2020, 3, 12, 14, 59, 58, 0, time.UTC))
+	next := waitFor(time.Date(2020, 3, 12, 16, 0, 0, 0, time.UTC))
+	if next.Base64File != keytab.NextBase64File || next.Kvno != keytab.NextKvno {
+		t.Fatalf("Expected keytab to rotate to the next keytab")
+	}
+
+}
+
+func TestOffsetRotation(t *testing.T) {
+
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 9, 30, 0, time.UTC))
+
+	config := &Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal: "bob@EXAMPLE.COM",
+				Seed:      "nIKSXX9nJU5klguCrzP3d",
+				Lifetime:  time.Hour,
+				Offset:    30 * time.Second,
+				Backend:   BackendNative,
+			},
+		},
+		Clock: fake,
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	// The period ends 30 seconds past the top of the minute so the keytab
+	// must rotate before the next top of the minute
+	waitFor := func(exp, before time.Time) {
+		for fake.Now().Before(before) {
+			fake.Advance(time.Second)
+			deadline := time.Now().Add(250 * time.Millisecond)
+			for time.Now().Before(deadline) {
+				keytab, err := cache.GetKeytab("bob@EXAMPLE.COM")
+				if err == nil && keytab.Exp == exp.Unix() {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		t.Fatalf("Keytab that expires at %s not ready before %s", exp, before)
+	}
+
+	waitFor(time.Date(2020, 3, 12, 15, 0, 30, 0, time.UTC), time.Date(2020, 3, 12, 14, 10, 30, 0, time.UTC))
+
+	fake.Set(time.Date(2020, 3, 12, 15, 0, 28, 0, time.UTC))
+	waitFor(time.Date(2020, 3, 12, 16, 0, 30, 0, time.UTC), time.Date(2020, 3, 12, 15, 0, 59, 0, time.UTC))
+
+}
+
+func TestScheduleKvno(t *testing.T) {
+
+	// The kvno can only be rotated if every period has the same length
+	for _, schedule := range []string{"@weekly", "0 2 * * sun"} {
+		_, err := (&Config{
+			Keytabs: []*Keytab{
+				&Keytab{
+					Principal:  "bob@EXAMPLE.COM",
+					Seed:       "nIKSXX9nJU5klguCrzP3d",
+					Schedule:   schedule,
+					Backend:    BackendNative,
+					RotateKvno: true,
+				},
+			},
+		}).Build()
+		if err == nil {
+			t.Fatalf("Expected error for rotateKvno with schedule %s", schedule)
+		}
+	}
+
+	cache, err := (&Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal:  "bob@EXAMPLE.COM",
+				Seed:       "nIKSXX9nJU5klguCrzP3d",
+				Schedule:   "12h",
+				Backend:    BackendNative,
+				RotateKvno: true,
+			},
+		},
+	}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	cache.Shutdown()
+
+}
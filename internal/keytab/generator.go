@@ -0,0 +1,206 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keytab
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// BackendKtpass Keytabs are created with ktpass against Active Directory
+	BackendKtpass = "ktpass"
+
+	// BackendKadmin Keytabs are created with kadmin against a MIT or Heimdal KDC
+	BackendKadmin = "kadmin"
+
+	// BackendNative Keytabs are written in process without contacting a KDC
+	BackendNative = "native"
+)
+
+const (
+	// EnctypeAES256 AES256 CTS mode with HMAC-SHA1-96 (RFC 3962)
+	EnctypeAES256 = "aes256-cts-hmac-sha1-96"
+
+	// EnctypeAES128 AES128 CTS mode with HMAC-SHA1-96 (RFC 3962)
+	EnctypeAES128 = "aes128-cts-hmac-sha1-96"
+
+	// EnctypeRC4 RC4 with HMAC-MD5 (RFC 4757). For legacy services only
+	EnctypeRC4 = "rc4-hmac"
+)
+
+const (
+	// PrincipalTypePrincipal Name of a user or service (default)
+	PrincipalTypePrincipal = "KRB5_NT_PRINCIPAL"
+
+	// PrincipalTypeSrvInst Service and other unique instance
+	PrincipalTypeSrvInst = "KRB5_NT_SRV_INST"
+
+	// PrincipalTypeSrvHst Service with host name as instance
+	PrincipalTypeSrvHst = "KRB5_NT_SRV_HST"
+)
+
+type enctype struct {
+	name   string
+	etype  int32
+	ktpass string
+}
+
+var enctypes = map[string]*enctype{
+	EnctypeAES256: &enctype{name: EnctypeAES256, etype: etypeAES256CTSHMACSHA196, ktpass: "AES256-SHA1"},
+	EnctypeAES128: &enctype{name: EnctypeAES128, etype: etypeAES128CTSHMACSHA196, ktpass: "AES128-SHA1"},
+	EnctypeRC4:    &enctype{name: EnctypeRC4, etype: etypeRC4HMAC, ktpass: "RC4-HMAC-NT"},
+}
+
+// Aliases are accepted so that the names used by ktpass may also be used
+var enctypeAliases = map[string]string{
+	"aes256":       EnctypeAES256,
+	"aes256-sha1":  EnctypeAES256,
+	"aes128":       EnctypeAES128,
+	"aes128-sha1":  EnctypeAES128,
+	"rc4":          EnctypeRC4,
+	"rc4-hmac-nt":  EnctypeRC4,
+	"arcfour-hmac": EnctypeRC4,
+}
+
+var principalTypes = map[string]int32{
+	PrincipalTypePrincipal: nameTypePrincipal,
+	PrincipalTypeSrvInst:   nameTypeSrvInst,
+	PrincipalTypeSrvHst:    nameTypeSrvHst,
+}
+
+// Spec Specification for a keytab that is passed to a Generator.
+//
+// Principal: The principal (or username) of the account the keytab is for
+//
+// SPNs: One or more service principal names that are written to the keytab.
+// Each SPN gets an entry for each enctype
+//
+// Password: The password the keys are derived from
+//
+// Enctypes: Zero or more encryption types by their canonical name. If empty
+// the Generator uses its own default
+//
+// Kvno: Key version number to be written to the keytab. Generators that
+// get the kvno from the KDC ignore this
+//
+// PrincipalType: The principal name type. Default is KRB5_NT_PRINCIPAL
+//
+// Time: The timestamp of the keytab entries for generators that write the
+// keytab themselves. The Cache uses the start of the period so that the next
+// keytab matches the keytab that is generated at the rotation. If zero the
+// current time is used
+type Spec struct {
+	Principal     string
+	SPNs          []string
+	Password      string
+	Enctypes      []string
+	Kvno          uint32
+	PrincipalType string
+	Time          time.Time
+}
+
+// Generator Interface. A Generator is responsible for creating a keytab for
+// a principal with the provided password and returning the content of the
+// keytab file base64 encoded. Depending on the implementation the password
+// of the principal on the KDC is set as part of the generation.
+type Generator interface {
+	NewKeytab(spec *Spec) (string, error)
+}
+
+// defaultBackend returns the backend that is used when a keytab does not
+// specify one. This preserves the behavior of selecting by platform.
+func defaultBackend() string {
+	if runtime.GOOS == "windows" {
+		return BackendKtpass
+	}
+	return BackendNative
+}
+
+// defaultGenerators returns the Generators that are available by default
+func defaultGenerators() map[string]Generator {
+	return map[string]Generator{
+		BackendKtpass: &Ktpass{},
+		BackendKadmin: &Kadmin{},
+		BackendNative: &Native{},
+	}
+}
+
+// normalizeEnctypes returns the canonical names for the provided enctypes
+// and an error if any are not supported
+func normalizeEnctypes(input []string) ([]string, error) {
+
+	var result []string
+	seen := make(map[string]bool)
+
+	for _, s := range input {
+
+		name := strings.ToLower(strings.TrimSpace(s))
+		if alias, exist := enctypeAliases[name]; exist {
+			name = alias
+		}
+
+		if _, exist := enctypes[name]; !exist {
+			return nil, fmt.Errorf("Encryption type %s is not supported", s)
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+
+	return result, nil
+}
+
+// normalizePrincipalType returns the canonical name for the principal type
+func normalizePrincipalType(input string) (string, error) {
+
+	if input == "" {
+		return PrincipalTypePrincipal, nil
+	}
+
+	name := strings.ToUpper(strings.TrimSpace(input))
+	if _, exist := principalTypes[name]; !exist {
+		return "", fmt.Errorf("Principal type %s is not supported", input)
+	}
+
+	return name, nil
+}
+
+func readKeytabFile(filename string) (string, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(content), nil
+}
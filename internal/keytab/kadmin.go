@@ -0,0 +1,155 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keytab
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultKadminLocalExe = "kadmin.local"
+	defaultKadminExe      = "kadmin"
+)
+
+// Kadmin Generator for MIT and Heimdal Kerberos. This is the counterpart of
+// ktpass for Active Directory. Each time a new keytab is needed the password
+// of the principal is changed on the KDC to the derived password with cpw
+// and the keytab is then exported with ktadd. The -norandkey option is passed
+// to ktadd so that the keys are not randomized on export and remain derived
+// from the password. This keeps multiple instances of the server in sync as
+// they will all set the same password.
+//
+// If Keytab is not set then kadmin.local is used. It must be ran on the KDC
+// host with privileges to read the KDC database. If Keytab is set then kadmin
+// is used to connect to the admin server authenticating as Principal with the
+// keys from Keytab. The admin principal must be granted the change password
+// and extract keys privileges in kadm5.acl. Note that older versions of MIT
+// kadmin only permit -norandkey with kadmin.local.
+//
+// If enctypes are specified they are passed to cpw with -e so that only keys
+// for those enctypes are created. Otherwise the supported_enctypes of the
+// realm are used. The kvno is incremented by the KDC on each cpw and the
+// principal type is set by the KDC so these are not taken from the Spec.
+//
+// The password is not passed with -pw as the command line of a process can be
+// read by any local user. Instead cpw prompts for it and it is written twice
+// to stdin.
+//
+// kadmin.local -q "cpw HTTP/bob@EXAMPLE.COM"
+// kadmin.local -q "ktadd -k keytab -norandkey HTTP/bob@EXAMPLE.COM"
+// kadmin -p admin/admin@EXAMPLE.COM -k -t admin.keytab -q "cpw HTTP/bob@EXAMPLE.COM"
+type Kadmin struct {
+	// Exe is the path to kadmin or kadmin.local. The default is kadmin.local
+	// or kadmin (when Keytab is set) from the PATH
+	Exe string
+	// Principal is the admin principal used to authenticate with Keytab
+	Principal string
+	// Keytab is the path to the keytab for the admin principal
+	Keytab string
+}
+
+// NewKeytab Sets the password for each SPN and returns the keytab. With MIT
+// and Heimdal each SPN is a principal of its own so the password is set on
+// each and each is added to the same keytab.
+func (t *Kadmin) NewKeytab(spec *Spec) (string, error) {
+
+	if len(spec.SPNs) == 0 {
+		return "", fmt.Errorf("At least one SPN is required")
+	}
+
+	dir, err := ioutil.TempDir("", "kt")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "file.keytab")
+
+	options := ""
+	if len(spec.Enctypes) > 0 {
+		var keysalts []string
+		for _, name := range spec.Enctypes {
+			keysalts = append(keysalts, name+":normal")
+		}
+		options = "-e " + strings.Join(keysalts, ",") + " "
+	}
+
+	for _, principal := range spec.SPNs {
+
+		// cpw prompts for the password and then for it again to confirm
+		err = t.run("cpw "+options+principal, spec.Password+"\n"+spec.Password+"\n")
+		if err != nil {
+			return "", err
+		}
+
+		err = t.run("ktadd -k "+filename+" -norandkey "+principal, "")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return readKeytabFile(filename)
+}
+
+// run executes the kadmin query with input written to stdin. The query must
+// not contain secrets as it is logged and visible in the process list.
+func (t *Kadmin) run(query, input string) error {
+
+	exe := defaultKadminLocalExe
+	args := []string{}
+
+	if t.Keytab != "" {
+		if t.Principal == "" {
+			return fmt.Errorf("Kadmin principal is required when keytab is set")
+		}
+		exe = defaultKadminExe
+		args = append(args, "-p")
+		args = append(args, t.Principal)
+		args = append(args, "-k")
+		args = append(args, "-t")
+		args = append(args, t.Keytab)
+	}
+
+	if t.Exe != "" {
+		exe = t.Exe
+	}
+
+	args = append(args, "-q")
+	args = append(args, query)
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmdOutput := &bytes.Buffer{}
+	cmd.Stdout = cmdOutput
+	cmd.Stderr = cmdOutput
+	err := cmd.Run()
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("command->%s -q %s failed; output->%s", exe, query, string(cmdOutput.Bytes())))
+		return err
+	}
+
+	zap.L().Debug(fmt.Sprintf("command->%s -q %s, output->%s", exe, query, string(cmdOutput.Bytes())))
+	return nil
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keytab
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
+)
+
+// stubKadmin is a stand in for kadmin that records its arguments one line per
+// invocation, records the password prompted for by cpw and writes a fake
+// keytab when asked to ktadd
+var stubKadmin = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/args.log"
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-q" ]; then
+		shift
+		query="$1"
+	fi
+	shift
+done
+case "$query" in
+	cpw*)
+		cat >> "$(dirname "$0")/stdin.log"
+		;;
+	ktadd*)
+		printf 'stub keytab' > "$(echo "$query" | cut -d' ' -f3)"
+		;;
+esac
+`
+
+func newStubKadmin(t *testing.T) (string, func()) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("stub kadmin requires a POSIX shell")
+	}
+
+	dir, err := ioutil.TempDir("", "kadmin")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	exe := filepath.Join(dir, "kadmin")
+	err = ioutil.WriteFile(exe, []byte(stubKadmin), 0700)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	return exe, func() { os.RemoveAll(dir) }
+}
+
+func readStubArgs(t *testing.T, exe string) []string {
+	return readStubLog(t, exe, "args.log")
+}
+
+func readStubLog(t *testing.T, exe, name string) []string {
+	b, err := ioutil.ReadFile(filepath.Join(filepath.Dir(exe), name))
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestKadminLocal(t *testing.T) {
+
+	exe, cleanup := newStubKadmin(t)
+	defer cleanup()
+
+	generator := &Kadmin{Exe: exe}
+
+	base64File, err := generator.NewKeytab(&Spec{Principal: "bob@EXAMPLE.COM", SPNs: []string{"HTTP/bob@EXAMPLE.COM"}, Password: "secretpassword"})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	content, _ := base64.StdEncoding.DecodeString(base64File)
+	if string(content) != "stub keytab" {
+		t.Fatalf("Unexpected keytab content %s", string(content))
+	}
+
+	args := readStubArgs(t, exe)
+
+	if len(args) != 2 {
+		t.Fatalf("Expected 2 invocations, got %d", len(args))
+	}
+
+	// The password must not be on the command line
+	if args[0] != "-q cpw HTTP/bob@EXAMPLE.COM" {
+		t.Fatalf("Unexpected cpw args %s", args[0])
+	}
+
+	input := readStubLog(t, exe, "stdin.log")
+	if len(input) != 2 || input[0] != "secretpassword" || input[1] != "secretpassword" {
+		t.Fatalf("Expected password and confirmation on stdin, got %v", input)
+	}
+
+	if !strings.HasPrefix(args[1], "-q ktadd -k ") || !strings.HasSuffix(args[1], " -norandkey HTTP/bob@EXAMPLE.COM") {
+		t.Fatalf("Unexpected ktadd args %s", args[1])
+	}
+
+}
+
+func TestKadminRemote(t *testing.T) {
+
+	exe, cleanup := newStubKadmin(t)
+	defer cleanup()
+
+	generator := &Kadmin{
+		Exe:       exe,
+		Principal: "admin/admin@EXAMPLE.COM",
+		Keytab:    "/etc/admin.keytab",
+	}
+
+	_, err := generator.NewKeytab(&Spec{Principal: "bob@EXAMPLE.COM", SPNs: []string{"HTTP/bob@EXAMPLE.COM"}, Password: "secretpassword"})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	for _, args := range readStubArgs(t, exe) {
+		if !strings.HasPrefix(args, "-p admin/admin@EXAMPLE.COM -k -t /etc/admin.keytab -q ") {
+			t.Fatalf("Unexpected args %s", args)
+		}
+	}
+
+	generator.Principal = ""
+	if _, err := generator.NewKeytab(&Spec{Principal: "bob@EXAMPLE.COM", SPNs: []string{"HTTP/bob@EXAMPLE.COM"}, Password: "secretpassword"}); err == nil {
+		t.Fatalf("Expected error when admin principal is missing")
+	}
+
+}
+
+func TestKadminRotation(t *testing.T) {
+
+	exe, cleanup := newStubKadmin(t)
+	defer cleanup()
+
+	config := &Config{
+		Keytabs: []*Keytab{
+			&Keytab{
+				Principal: "bob@EXAMPLE.COM",
+				Seed:      "nIKSXX9nJU5klguCrzP3d",
+				Lifetime:  time.Minute,
+				Backend:   BackendKadmin,
+			},
+		},
+		Generators: map[string]Generator{
+			BackendKadmin: &Kadmin{Exe: exe},
+		},
+		// The clock is not advanced so that the run loop does not queue the
+		// keytab while it is updated by the test
+		Clock: clock.NewFake(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC)),
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	now := cache.getTime()
+	cache.internal["bob@EXAMPLE.COM"].update(now)
+
+	keytab, err := cache.GetKeytab("bob@EXAMPLE.COM")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if keytab.Principal != "HTTP/bob@EXAMPLE.COM" {
+		t.Fatalf("Unexpected principal %s", keytab.Principal)
+	}
+
+	// A second update in the same period must not rotate the password again
+	cache.internal["bob@EXAMPLE.COM"].update(now)
+
+	// The next period rotates to a new password
+	cache.internal["bob@EXAMPLE.COM"].update(now.Add(time.Minute))
+
+	args := readStubArgs(t, exe)
+	if len(args) != 4 {
+		t.Fatalf("Expected 4 invocations, got %d", len(args))
+	}
+
+	input := readStubLog(t, exe, "stdin.log")
+	if len(input) != 4 || input[0] == input[2] {
+		t.Fatalf("Expected password to change between periods")
+	}
+
+	config.Keytabs[0].Backend = "unknown"
+	if _, err := config.Build(); err == nil {
+		t.Fatalf("Expected error for unknown backend")
+	}
+
+}
@@ -21,19 +21,55 @@ import (
 	"time"
 
 	"github.com/jinzhu/copier"
+	"github.com/jodydadescott/tokens2secrets/internal/seed"
 )
 
 // Keytab contain credentials in the form of a username (or principal) and an
 // encrypted password. Keytabs are used to prove identity specifically for
 // services and scripts.
+//
+// Principals: Service principal names in the keytab. See expandSPNs
+//
+// NextBase64File: Keytab of the next period with NextExp and NextKvno. Only
+// set in the second half of the period and with the native backend
+//
+// Idle: How long a principal provisioned from a pattern is kept unused
+//
+// Derivation: seed (default) or hkdf-sha256-v1
+//
+// Seeds: See seed.Version
+//
+// Schedule, Offset and Stagger: See timeperiod.Config. RotateKvno is not
+// supported with a calendar interval or cron expression
 type Keytab struct {
-	Principal  string        `json:"principal,omitempty" yaml:"principal,omitempty"`
-	Seed       string        `json:"seed,omitempty" yaml:"seed,omitempty"`
-	Base64File string        `json:"base64file,omitempty" yaml:"base64file,omitempty"`
-	Exp        int64         `json:"exp,omitempty" yaml:"exp,omitempty"`
-	Lifetime   time.Duration `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
+	Principal      string         `json:"principal,omitempty" yaml:"principal,omitempty"`
+	Principals     []string       `json:"principals,omitempty" yaml:"principals,omitempty"`
+	Seed           string         `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Base64File     string         `json:"base64file,omitempty" yaml:"base64file,omitempty"`
+	Exp            int64          `json:"exp,omitempty" yaml:"exp,omitempty"`
+	Kvno           int            `json:"kvno,omitempty" yaml:"kvno,omitempty"`
+	NextBase64File string         `json:"nextBase64file,omitempty" yaml:"nextBase64file,omitempty"`
+	NextExp        int64          `json:"nextExp,omitempty" yaml:"nextExp,omitempty"`
+	NextKvno       int            `json:"nextKvno,omitempty" yaml:"nextKvno,omitempty"`
+	Lifetime       time.Duration  `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
+	Backend        string         `json:"backend,omitempty" yaml:"backend,omitempty"`
+	Enctypes       []string       `json:"enctypes,omitempty" yaml:"enctypes,omitempty"`
+	RotateKvno     bool           `json:"rotateKvno,omitempty" yaml:"rotateKvno,omitempty"`
+	PrincipalType  string         `json:"principalType,omitempty" yaml:"principalType,omitempty"`
+	Service        string         `json:"service,omitempty" yaml:"service,omitempty"`
+	Host           string         `json:"host,omitempty" yaml:"host,omitempty"`
+	SPNs           []string       `json:"spns,omitempty" yaml:"spns,omitempty"`
+	Idle           time.Duration  `json:"idle,omitempty" yaml:"idle,omitempty"`
+	Derivation     string         `json:"derivation,omitempty" yaml:"derivation,omitempty"`
+	Seeds          []*SeedVersion `json:"seeds,omitempty" yaml:"seeds,omitempty"`
+	Schedule       string         `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	Offset         time.Duration  `json:"offset,omitempty" yaml:"offset,omitempty"`
+	Stagger        bool           `json:"stagger,omitempty" yaml:"stagger,omitempty"`
 }
 
+// SeedVersion A seed and the time it becomes active. See seed.Version
+type SeedVersion = seed.Version
+
 // JSON Return JSON String representation
 func (t *Keytab) JSON() string {
 	j, _ := json.Marshal(t)
@@ -46,3 +82,50 @@ func (t *Keytab) Copy() *Keytab {
 	copier.Copy(&clone, &t)
 	return clone
 }
+
+// Batch Result of a request for multiple keytabs. Each principal that was
+// authorized and available is in Keytabs. Each principal that was not is in
+// Errors with the reason.
+type Batch struct {
+	Keytabs map[string]*Keytab `json:"keytabs,omitempty" yaml:"keytabs,omitempty"`
+	Errors  map[string]string  `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+// JSON Return JSON String representation
+func (t *Batch) JSON() string {
+	j, _ := json.Marshal(t)
+	return string(j)
+}
+
+const (
+	// HealthOK Keytab was generated
+	HealthOK = "ok"
+
+	// HealthPending Keytab has not been generated yet
+	HealthPending = "pending"
+
+	// HealthFailing Keytab generation failed and is being retried
+	HealthFailing = "failing"
+)
+
+// Health Health of a keytab. A keytab that is failing is retried with backoff
+// until it succeeds. Failures are the consecutive failures and NextRetry is
+// when the next attempt is made. Times are in UNIX seconds. QueueTime is how
+// long the last update waited for a worker and RunTime how long it took.
+type Health struct {
+	Principal   string        `json:"principal,omitempty" yaml:"principal,omitempty"`
+	State       string        `json:"state,omitempty" yaml:"state,omitempty"`
+	LastSuccess int64         `json:"lastSuccess,omitempty" yaml:"lastSuccess,omitempty"`
+	LastFailure int64         `json:"lastFailure,omitempty" yaml:"lastFailure,omitempty"`
+	LastError   string        `json:"lastError,omitempty" yaml:"lastError,omitempty"`
+	Failures    int           `json:"failures,omitempty" yaml:"failures,omitempty"`
+	NextRetry   int64         `json:"nextRetry,omitempty" yaml:"nextRetry,omitempty"`
+	QueueTime   time.Duration `json:"queueTime,omitempty" yaml:"queueTime,omitempty"`
+	RunTime     time.Duration `json:"runTime,omitempty" yaml:"runTime,omitempty"`
+}
+
+// JSON Return JSON String representation
+func (t *Health) JSON() string {
+	j, _ := json.Marshal(t)
+	return string(j)
+}
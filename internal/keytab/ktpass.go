@@ -0,0 +1,194 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keytab
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const defaultKtpassExe = "C:\\Windows\\System32\\ktpass"
+
+// Ktpass Generator for the Windows Kerberos Implementation (Active Directory).
+// Active Directory allows for the creation of principals that are mapped to
+// a user account. Only one principal may be mapped to a user account at a
+// time. Once a keytab is created it will remain valid until the principal is
+// removed or the password is changed or a new keytab is created. The windows
+// utility ktpass is used to create the keytabs.
+// The ktpass command is executed directly on the host. Therefore this should
+// be ran on a Windows system that is a member of the target domain. It must
+// also be ran with privileges to allow the creation of keytabs. Generally this
+// is a Domain Admin. If running as a service it is necessary that it be
+// configured to run as a domain admin or user with the privileges necessary
+// to create keytabs.
+//
+// Information about the ktpass utility is as follows
+// Exe: C:\Windows\System32\ktpass
+// Documentation: https://docs.microsoft.com/en-us/previous-versions/windows/it-pro/windows-server-2012-r2-and-2012/cc753771(v=ws.11)
+// [/out <FileName>]
+// [/princ <PrincipalName>]
+// [/mapuser <UserAccount>]
+// [/mapop {add|set}] [{-|+}desonly] [/in <FileName>]
+// [/pass {Password|*|{-|+}rndpass}]
+// [/minpass]
+// [/maxpass]
+// [/crypto {DES-CBC-CRC|DES-CBC-MD5|RC4-HMAC-NT|AES256-SHA1|AES128-SHA1|All}]
+// [/itercount]
+// [/ptype {KRB5_NT_PRINCIPAL|KRB5_NT_SRV_INST|KRB5_NT_SRV_HST}]
+// [/kvno <KeyVersionNum>]
+// [/answer {-|+}]
+// [/target]
+// [/rawsalt] [{-|+}dumpsalt] [{-|+}setupn] [{-|+}setpass <Password>]  [/?|/h|/help]
+//
+// Use +DumpSalt to dump MIT Salt to output
+//
+// Notes about ktpass failure functionality
+// Testing on Windows Server 2019 reveals that if the user lacks the
+// privileges to create keytabs the ktpass utility does not create the
+// keytab but also still exits with 0 and nothing is sent to the stdout
+// This was with a service account and stderr was not checked. For this
+// reason we will return an auth err if the file does not exist. This
+// should be refined in the future.
+//
+// ktpass -mapUser bob@EXAMPLE.COM -pass ** -mapOp set -crypto AES256-SHA1 -ptype KRB5_NT_PRINCIPAL -princ HTTP/bob@EXAMPLE.COM -kvno 1 -out keytab
+type Ktpass struct {
+	// Exe is the path to ktpass. Default is C:\Windows\System32\ktpass
+	Exe string
+}
+
+// NewKeytab Sets the password for the principal and returns the keytab.
+// ktpass only accepts a single SPN and crypto type per invocation. When more
+// then one SPN or enctype is requested the first invocation sets the password
+// and each following invocation reads the previous keytab with -in and adds
+// the keys for the next SPN and enctype with -setpass so that the password
+// (and the kvno on the account) is not changed again. The first SPN replaces
+// the SPNs mapped to the account and each additional SPN is added.
+func (t *Ktpass) NewKeytab(spec *Spec) (string, error) {
+
+	if len(spec.SPNs) == 0 {
+		return "", fmt.Errorf("At least one SPN is required")
+	}
+
+	dir, err := ioutil.TempDir("", "kt")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	names := spec.Enctypes
+	if len(names) == 0 {
+		names = []string{EnctypeAES256}
+	}
+
+	ptype := PrincipalTypePrincipal
+	if spec.PrincipalType != "" {
+		ptype = spec.PrincipalType
+	}
+
+	kvno := spec.Kvno
+	if kvno == 0 {
+		kvno = 1
+	}
+
+	var filename string
+	count := 0
+
+	for i, spn := range spec.SPNs {
+
+		mapOp := "set"
+		if i > 0 {
+			mapOp = "add"
+		}
+
+		for _, name := range names {
+
+			enctype, exist := enctypes[name]
+			if !exist {
+				return "", fmt.Errorf("Encryption type %s is not supported", name)
+			}
+
+			infile := filename
+			filename = filepath.Join(dir, fmt.Sprintf("file%d.keytab", count))
+			count++
+
+			err = t.run(spec.Principal, spn, spec.Password, mapOp, enctype.ktpass, ptype, kvno, infile, filename)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return readKeytabFile(filename)
+}
+
+func (t *Ktpass) run(principal, spn, password, mapOp, crypto, ptype string, kvno uint32, infile, outfile string) error {
+
+	exe := defaultKtpassExe
+	if t.Exe != "" {
+		exe = t.Exe
+	}
+	args := []string{}
+
+	args = append(args, "-mapUser")
+	args = append(args, principal)
+	args = append(args, "-pass")
+	args = append(args, password)
+	args = append(args, "-mapOp")
+	args = append(args, mapOp)
+	args = append(args, "-crypto")
+	args = append(args, crypto)
+	args = append(args, "-ptype")
+	args = append(args, ptype)
+	args = append(args, "-princ")
+	args = append(args, spn)
+	args = append(args, "-kvno")
+	args = append(args, strconv.FormatUint(uint64(kvno), 10))
+	if infile != "" {
+		args = append(args, "-in")
+		args = append(args, infile)
+		args = append(args, "-setpass")
+	}
+	args = append(args, "-out")
+	args = append(args, outfile)
+
+	logarg := exe
+	for _, arg := range args {
+		logarg = logarg + " " + arg
+	}
+
+	//zap.L().Debug(fmt.Sprintf("command->%s", logarg))
+
+	cmd := exec.Command(exe, args...)
+	cmdOutput := &bytes.Buffer{}
+	cmd.Stdout = cmdOutput
+	err := cmd.Run()
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("exec.Command(%s, %s)", exe, args))
+		return err
+	}
+
+	zap.L().Debug(fmt.Sprintf("command->%s, output->%s", logarg, string(cmdOutput.Bytes())))
+
+	return nil
+}
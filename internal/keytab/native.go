@@ -0,0 +1,372 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keytab
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Kerberos encryption types and name types as defined in RFC 3961, RFC 3962,
+// RFC 4757 and RFC 4120
+const (
+	etypeAES128CTSHMACSHA196 int32 = 17
+	etypeAES256CTSHMACSHA196 int32 = 18
+	etypeRC4HMAC             int32 = 23
+
+	nameTypePrincipal int32 = 1
+	nameTypeSrvInst   int32 = 2
+	nameTypeSrvHst    int32 = 3
+
+	// Default PBKDF2 iteration count for the AES enctypes (RFC 3962)
+	aesIterations = 4096
+
+	keytabVersion = 0x0502
+)
+
+// keytabEntry is a single key for a single principal. The MIT keytab format
+// is a sequence of these entries; a principal with several enctypes has one
+// entry for each enctype.
+type keytabEntry struct {
+	components []string
+	realm      string
+	nameType   int32
+	timestamp  uint32
+	kvno       uint32
+	etype      int32
+	key        []byte
+}
+
+// Native Generator that creates a keytab in the MIT binary format (version
+// 0x0502) without relying on any external utility. The keys are derived from
+// the password using the string-to-key functions from RFC 3962 (AES) and
+// RFC 4757 (RC4) with the default salt of realm followed by the principal
+// components. Unlike ktpass this does not set the password of the principal
+// on the KDC. It only produces the keytab that matches the password. The
+// default enctypes are AES256 and AES128.
+type Native struct {
+}
+
+// NewKeytab Returns keytab for principal with keys derived from password
+func (t *Native) NewKeytab(spec *Spec) (string, error) {
+
+	if len(spec.SPNs) == 0 {
+		return "", fmt.Errorf("At least one SPN is required")
+	}
+
+	names := spec.Enctypes
+	if len(names) == 0 {
+		names = []string{EnctypeAES256, EnctypeAES128}
+	}
+
+	nameType := nameTypePrincipal
+	if spec.PrincipalType != "" {
+		var exist bool
+		nameType, exist = principalTypes[spec.PrincipalType]
+		if !exist {
+			return "", fmt.Errorf("Principal type %s is not supported", spec.PrincipalType)
+		}
+	}
+
+	kvno := spec.Kvno
+	if kvno == 0 {
+		kvno = 1
+	}
+
+	now := spec.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	timestamp := uint32(now.Unix())
+
+	var entries []*keytabEntry
+
+	for _, spn := range spec.SPNs {
+
+		components, realm, err := parsePrincipal(spn)
+		if err != nil {
+			return "", err
+		}
+
+		salt := realm + strings.Join(components, "")
+
+		for _, name := range names {
+
+			enctype, exist := enctypes[name]
+			if !exist {
+				return "", fmt.Errorf("Encryption type %s is not supported", name)
+			}
+
+			key, err := stringToKey(enctype.etype, spec.Password, salt)
+			if err != nil {
+				return "", err
+			}
+
+			entries = append(entries, &keytabEntry{
+				components: components,
+				realm:      realm,
+				nameType:   nameType,
+				timestamp:  timestamp,
+				kvno:       kvno,
+				etype:      enctype.etype,
+				key:        key,
+			})
+		}
+	}
+
+	content, err := marshalKeytab(entries)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(content), nil
+}
+
+// parsePrincipal splits a principal in the form primary/instance@REALM into
+// its name components and realm
+func parsePrincipal(principal string) ([]string, string, error) {
+
+	i := strings.LastIndex(principal, "@")
+	if i <= 0 || i == len(principal)-1 {
+		return nil, "", fmt.Errorf("Principal %s is missing realm", principal)
+	}
+
+	realm := principal[i+1:]
+	components := strings.Split(principal[:i], "/")
+
+	for _, component := range components {
+		if component == "" {
+			return nil, "", fmt.Errorf("Principal %s has an empty component", principal)
+		}
+	}
+
+	return components, realm, nil
+}
+
+// marshalKeytab returns the keytab file content for the provided entries
+func marshalKeytab(entries []*keytabEntry) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, uint16(keytabVersion))
+
+	for _, entry := range entries {
+
+		e := &bytes.Buffer{}
+
+		binary.Write(e, binary.BigEndian, uint16(len(entry.components)))
+		writeCountedString(e, []byte(entry.realm))
+		for _, component := range entry.components {
+			writeCountedString(e, []byte(component))
+		}
+		binary.Write(e, binary.BigEndian, entry.nameType)
+		binary.Write(e, binary.BigEndian, entry.timestamp)
+		// The 8 bit kvno is kept for older readers. The full 32 bit kvno
+		// follows the key and takes precedence when present
+		e.WriteByte(uint8(entry.kvno))
+		binary.Write(e, binary.BigEndian, uint16(entry.etype))
+		writeCountedString(e, entry.key)
+		binary.Write(e, binary.BigEndian, entry.kvno)
+
+		if e.Len() > 0x7fffffff {
+			return nil, fmt.Errorf("Keytab entry is to large")
+		}
+
+		binary.Write(buf, binary.BigEndian, int32(e.Len()))
+		buf.Write(e.Bytes())
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeCountedString(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint16(len(b)))
+	buf.Write(b)
+}
+
+// stringToKey returns the key for the enctype derived from password and salt
+func stringToKey(etype int32, password, salt string) ([]byte, error) {
+	switch etype {
+	case etypeAES128CTSHMACSHA196:
+		return aesStringToKey(password, salt, aesIterations, 16)
+	case etypeAES256CTSHMACSHA196:
+		return aesStringToKey(password, salt, aesIterations, 32)
+	case etypeRC4HMAC:
+		return rc4StringToKey(password), nil
+	}
+	return nil, fmt.Errorf("Encryption type %d is not supported", etype)
+}
+
+// rc4StringToKey implements the string-to-key function for RC4-HMAC from
+// RFC 4757; the key is the MD4 hash of the UTF-16LE password and is not salted
+func rc4StringToKey(password string) []byte {
+	encoded := utf16.Encode([]rune(password))
+	b := make([]byte, len(encoded)*2)
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(b[i*2:], r)
+	}
+	hash := md4.New()
+	hash.Write(b)
+	return hash.Sum(nil)
+}
+
+// aesStringToKey implements the string-to-key function for the AES enctypes
+// from RFC 3962; tkey = random-to-key(PBKDF2(password, salt, iterations))
+// and key = DK(tkey, "kerberos")
+func aesStringToKey(password, salt string, iterations, keyLength int) ([]byte, error) {
+	tkey := pbkdf2SHA1([]byte(password), []byte(salt), iterations, keyLength)
+	return deriveKey(tkey, []byte("kerberos"))
+}
+
+// deriveKey implements DK(Key, Constant) from RFC 3961 for AES where
+// random-to-key is the identity function
+func deriveKey(key, constant []byte) ([]byte, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// For a single block of input the CBC-CTS mode used by the AES enctypes
+	// is the same as encrypting the block directly
+	in := nfold(constant, block.BlockSize())
+	out := make([]byte, 0, len(key)+block.BlockSize())
+
+	for len(out) < len(key) {
+		next := make([]byte, block.BlockSize())
+		block.Encrypt(next, in)
+		out = append(out, next...)
+		in = next
+	}
+
+	return out[:len(key)], nil
+}
+
+// pbkdf2SHA1 implements PBKDF2 from RFC 2898 using HMAC-SHA1 as the
+// pseudorandom function
+func pbkdf2SHA1(password, salt []byte, iterations, keyLength int) []byte {
+
+	prf := hmac.New(sha1.New, password)
+	hashLength := prf.Size()
+	blocks := (keyLength + hashLength - 1) / hashLength
+
+	var counter [4]byte
+	result := make([]byte, 0, blocks*hashLength)
+	u := make([]byte, hashLength)
+
+	for block := 1; block <= blocks; block++ {
+
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+
+		t := make([]byte, hashLength)
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		result = append(result, t...)
+	}
+
+	return result[:keyLength]
+}
+
+// nfold implements the n-fold operation from RFC 3961 section 5.1. The input
+// is replicated with a 13 bit right rotation for each copy until the length
+// is the least common multiple of the input and output lengths. The copies
+// are then added together in n byte chunks using one's complement addition.
+func nfold(in []byte, n int) []byte {
+
+	inBits := len(in) * 8
+	outBits := n * 8
+	lcm := inBits * outBits / gcd(inBits, outBits)
+
+	replicated := make([]byte, 0, lcm/8)
+	for i := 0; i < lcm/inBits; i++ {
+		replicated = append(replicated, rotateRight(in, 13*i)...)
+	}
+
+	out := make([]byte, n)
+	for i := 0; i < len(replicated); i += n {
+		out = onesComplementAdd(out, replicated[i:i+n])
+	}
+
+	return out
+}
+
+func onesComplementAdd(a, b []byte) []byte {
+
+	out := make([]byte, len(a))
+	carry := 0
+
+	for i := len(a) - 1; i >= 0; i-- {
+		sum := int(a[i]) + int(b[i]) + carry
+		out[i] = byte(sum)
+		carry = sum >> 8
+	}
+
+	// End around carry
+	for carry > 0 {
+		for i := len(out) - 1; i >= 0 && carry > 0; i-- {
+			sum := int(out[i]) + carry
+			out[i] = byte(sum)
+			carry = sum >> 8
+		}
+	}
+
+	return out
+}
+
+func rotateRight(in []byte, bits int) []byte {
+
+	totalBits := len(in) * 8
+	out := make([]byte, len(in))
+
+	for i := 0; i < totalBits; i++ {
+		if in[i/8]&(0x80>>uint(i%8)) != 0 {
+			j := (i + bits) % totalBits
+			out[j/8] |= 0x80 >> uint(j%8)
+		}
+	}
+
+	return out
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,172 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keytab
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestNFold(t *testing.T) {
+
+	// Test vectors from RFC 3961 appendix A.1
+	vectors := []struct {
+		input  string
+		n      int
+		expect string
+	}{
+		{"012345", 8, "be072631276b1955"},
+		{"password", 7, "78a07b6caf85fa"},
+		{"Rough Consensus, and Running Code", 8, "bb6ed30870b7f0e0"},
+		{"password", 21, "59e4a8ca7c0385c3c37b3f6d2000247cb6e6bd5b3e"},
+		{"kerberos", 16, "6b65726265726f737b9b5b2b93132b93"},
+	}
+
+	for _, v := range vectors {
+		result := hex.EncodeToString(nfold([]byte(v.input), v.n))
+		if result != v.expect {
+			t.Fatalf("nfold(%s, %d) expected %s, got %s", v.input, v.n*8, v.expect, result)
+		}
+	}
+
+}
+
+func TestAESStringToKey(t *testing.T) {
+
+	// Test vectors from RFC 3962 appendix B
+	vectors := []struct {
+		iterations, keyLength int
+		expect                string
+	}{
+		{1, 16, "42263c6e89f4fc28b8df68ee09799f15"},
+		{1, 32, "fe697b52bc0d3ce14432ba036a92e65bbb52280990a2fa27883998d72af30161"},
+		{1200, 16, "4c01cd46d632d01e6dbe230a01ed642a"},
+		{1200, 32, "55a6ac740ad17b4846941051e1e8b0a7548d93b0ab30a8bc3ff16280382b8c2a"},
+	}
+
+	for _, v := range vectors {
+		key, err := aesStringToKey("password", "ATHENA.MIT.EDUraeburn", v.iterations, v.keyLength)
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+		result := hex.EncodeToString(key)
+		if result != v.expect {
+			t.Fatalf("aesStringToKey(%d, %d) expected %s, got %s", v.iterations, v.keyLength, v.expect, result)
+		}
+	}
+
+}
+
+func TestRC4StringToKey(t *testing.T) {
+	// NT hash of "password"
+	result := hex.EncodeToString(rc4StringToKey("password"))
+	if result != "8846f7eaee8fb117ad06bdd830b7586c" {
+		t.Fatalf("rc4StringToKey expected 8846f7eaee8fb117ad06bdd830b7586c, got %s", result)
+	}
+}
+
+func TestNativeNewKeytab(t *testing.T) {
+
+	base64File, err := (&Native{}).NewKeytab(&Spec{
+		Principal: "bob@EXAMPLE.COM",
+		SPNs:      []string{"HTTP/bob@EXAMPLE.COM", "host/bob.example.com@EXAMPLE.COM"},
+		Password:  "password",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	content, err := base64.StdEncoding.DecodeString(base64File)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if binary.BigEndian.Uint16(content) != keytabVersion {
+		t.Fatalf("Expected keytab version %x, got %x", keytabVersion, binary.BigEndian.Uint16(content))
+	}
+
+	// Walk the entries; one for each SPN and AES enctype
+	entries := 0
+	offset := 2
+	for offset < len(content) {
+		size := int(binary.BigEndian.Uint32(content[offset:]))
+		offset = offset + 4 + size
+		entries++
+	}
+
+	if offset != len(content) {
+		t.Fatalf("Keytab entry sizes do not match content length")
+	}
+
+	if entries != 4 {
+		t.Fatalf("Expected 4 entries, got %d", entries)
+	}
+
+	if _, err := (&Native{}).NewKeytab(&Spec{Principal: "bob", SPNs: []string{"HTTP/bob"}, Password: "password"}); err == nil {
+		t.Fatalf("Expected error for SPN without realm")
+	}
+
+	if _, err := (&Native{}).NewKeytab(&Spec{Principal: "bob@EXAMPLE.COM", Password: "password"}); err == nil {
+		t.Fatalf("Expected error for missing SPNs")
+	}
+
+}
+
+func TestNativeEnctypes(t *testing.T) {
+
+	enctypes, err := normalizeEnctypes([]string{"RC4-HMAC-NT", "aes128", EnctypeAES128})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if len(enctypes) != 2 || enctypes[0] != EnctypeRC4 || enctypes[1] != EnctypeAES128 {
+		t.Fatalf("Unexpected enctypes %s", enctypes)
+	}
+
+	if _, err := normalizeEnctypes([]string{"des-cbc-crc"}); err == nil {
+		t.Fatalf("Expected error for unsupported enctype")
+	}
+
+	base64File, err := (&Native{}).NewKeytab(&Spec{
+		Principal:     "bob@EXAMPLE.COM",
+		SPNs:          []string{"HTTP/bob@EXAMPLE.COM"},
+		Password:      "password",
+		Enctypes:      enctypes,
+		Kvno:          7,
+		PrincipalType: PrincipalTypeSrvHst,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	content, _ := base64.StdEncoding.DecodeString(base64File)
+
+	// The first entry is for RC4; the key and full kvno are at the end
+	entry := content[6 : 6+binary.BigEndian.Uint32(content[2:])]
+	key := entry[len(entry)-20 : len(entry)-4]
+
+	if hex.EncodeToString(key) != "8846f7eaee8fb117ad06bdd830b7586c" {
+		t.Fatalf("Unexpected RC4 key %x", key)
+	}
+
+	if binary.BigEndian.Uint32(entry[len(entry)-4:]) != 7 {
+		t.Fatalf("Expected kvno 7, got %d", binary.BigEndian.Uint32(entry[len(entry)-4:]))
+	}
+
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keytab
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultWorkers = 8
+
+	// queueSize is the number of keytabs that may wait for a worker
+	queueSize = 1024
+)
+
+// job is a request to update the keytab of a wrapper. Keytabs are not updated
+// directly but are queued and processed by a bounded number of workers so
+// that rotating many principals at the top of the period does not overwhelm
+// the KDC or the host.
+type job struct {
+	wrapper *wrapper
+	queued  time.Time
+}
+
+// startWorkers starts the workers that process the queue
+func (t *Cache) startWorkers(workers int) {
+	t.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go t.worker()
+	}
+}
+
+func (t *Cache) worker() {
+	defer t.wg.Done()
+	for {
+		select {
+		case <-t.closeTimer:
+			return
+		case job := <-t.queue:
+			t.process(job)
+		}
+	}
+}
+
+// enqueue queues the wrapper for update unless it is already queued. This
+// does not block. If the queue is full the wrapper is not queued and remains
+// due so that it is queued on a later tick.
+func (t *Cache) enqueue(wrapper *wrapper) {
+
+	if !atomic.CompareAndSwapInt32(&wrapper.queued, 0, 1) {
+		zap.L().Debug(fmt.Sprintf("Keytab %s is already queued", wrapper.principal))
+		return
+	}
+
+	select {
+	case t.queue <- &job{wrapper: wrapper, queued: t.clock.Now()}:
+	default:
+		atomic.StoreInt32(&wrapper.queued, 0)
+		zap.L().Debug(fmt.Sprintf("Keytab queue is full; %s is queued later", wrapper.principal))
+	}
+}
+
+// process updates the keytab as of the time it is processed and records how
+// long the job waited and ran
+func (t *Cache) process(job *job) {
+
+	defer atomic.StoreInt32(&job.wrapper.queued, 0)
+
+	start := t.getTime()
+	job.wrapper.update(start)
+	runTime := t.getTime().Sub(start)
+	queueTime := start.Sub(job.queued)
+
+	job.wrapper.mutex.Lock()
+	job.wrapper.queueTime = queueTime
+	job.wrapper.runTime = runTime
+	job.wrapper.mutex.Unlock()
+
+	zap.L().Debug(fmt.Sprintf("Keytab %s processed; queueTime=%s, runTime=%s", job.wrapper.principal, queueTime, runTime))
+}
+
+// wrappers returns a snapshot of the wrappers so that they may be queued
+// without holding the lock
+func (t *Cache) wrappers() []*wrapper {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	var result []*wrapper
+	for _, wrapper := range t.internal {
+		result = append(result, wrapper)
+	}
+	return result
+}
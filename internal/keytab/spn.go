@@ -0,0 +1,114 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keytab
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultService     = "HTTP"
+	defaultSPNTemplate = "{service}/{principal}"
+)
+
+var spnVariableRegex = regexp.MustCompile(`\{[a-zA-Z]+\}`)
+
+// expandSPNs returns the service principal names for the principal from the
+// provided templates. A template may use the variables
+//
+// {service}: The service. Default is HTTP
+//
+// {host}: The host. Default is the user part of the principal
+//
+// {user}: The user part of the principal
+//
+// {principal}: The principal
+//
+// {REALM}: The realm of the principal in upper case
+//
+// {realm}: The realm of the principal in lower case
+//
+// If a template does not end in a realm then the realm of the principal is
+// appended. If no templates are provided the default {service}/{principal}
+// is used which results in HTTP/principal.
+func expandSPNs(principal, service, host string, templates []string) ([]string, error) {
+
+	i := strings.LastIndex(principal, "@")
+	if i <= 0 {
+		return nil, fmt.Errorf("Principal %s is missing realm", principal)
+	}
+
+	user := principal[:i]
+	realm := principal[i+1:]
+
+	if service == "" {
+		service = defaultService
+	}
+
+	if host == "" {
+		host = user
+	}
+
+	variables := map[string]string{
+		"{service}":   service,
+		"{host}":      host,
+		"{user}":      user,
+		"{principal}": principal,
+		"{REALM}":     strings.ToUpper(realm),
+		"{realm}":     strings.ToLower(realm),
+	}
+
+	if len(templates) == 0 {
+		templates = []string{defaultSPNTemplate}
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+
+	for _, template := range templates {
+
+		var unknown string
+		spn := spnVariableRegex.ReplaceAllStringFunc(template, func(s string) string {
+			if value, exist := variables[s]; exist {
+				return value
+			}
+			unknown = s
+			return s
+		})
+
+		if unknown != "" {
+			return nil, fmt.Errorf("SPN template %s has unknown variable %s", template, unknown)
+		}
+
+		if !strings.Contains(spn, "@") {
+			spn = spn + "@" + realm
+		}
+
+		if _, _, err := parsePrincipal(spn); err != nil {
+			return nil, fmt.Errorf("SPN template %s is invalid; %s", template, err.Error())
+		}
+
+		if !seen[spn] {
+			seen[spn] = true
+			result = append(result, spn)
+		}
+	}
+
+	return result, nil
+}
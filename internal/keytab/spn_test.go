@@ -0,0 +1,68 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keytab
+
+import (
+	"testing"
+)
+
+func TestExpandSPNs(t *testing.T) {
+
+	spns, err := expandSPNs("bob@EXAMPLE.COM", "", "", nil)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if len(spns) != 1 || spns[0] != "HTTP/bob@EXAMPLE.COM" {
+		t.Fatalf("Unexpected SPNs %s", spns)
+	}
+
+	spns, err = expandSPNs("svc_sql@Example.Com", "MSSQLSvc", "db1.example.com", []string{
+		"{service}/{host}:1433@{REALM}",
+		"{service}/{host}",
+		"host/{user}.{realm}",
+		"{service}/{host}",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	expect := []string{
+		"MSSQLSvc/db1.example.com:1433@EXAMPLE.COM",
+		"MSSQLSvc/db1.example.com@Example.Com",
+		"host/svc_sql.example.com@Example.Com",
+	}
+
+	if len(spns) != len(expect) {
+		t.Fatalf("Expected %s, got %s", expect, spns)
+	}
+
+	for i := range expect {
+		if spns[i] != expect[i] {
+			t.Fatalf("Expected %s, got %s", expect[i], spns[i])
+		}
+	}
+
+	if _, err := expandSPNs("bob@EXAMPLE.COM", "", "", []string{"{service}/{hostname}"}); err == nil {
+		t.Fatalf("Expected error for unknown variable")
+	}
+
+	if _, err := expandSPNs("bob", "", "", nil); err == nil {
+		t.Fatalf("Expected error for principal without realm")
+	}
+
+}
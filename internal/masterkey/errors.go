@@ -0,0 +1,30 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package masterkey
+
+import "errors"
+
+var (
+	// ErrNoKey Master key is not set in the key file or environment
+	ErrNoKey error = errors.New("Master key is not set")
+
+	// ErrInvalidKey Master key is not 32 bytes base64 encoded
+	ErrInvalidKey error = errors.New("Master key must be 32 bytes base64 encoded")
+
+	// ErrDecrypt Value could not be decrypted with the master key
+	ErrDecrypt error = errors.New("Unable to decrypt value; wrong master key or value is corrupt")
+)
@@ -0,0 +1,225 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package masterkey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// EnvKey Environment variable the master key is read from when no key
+	// file is set
+	EnvKey = "TOKENS2SECRETS_MASTER_KEY"
+
+	keySize = 32
+
+	// DerivationSeed Passwords are derived from the per item seed with TOTP
+	// and SHA256. This is the default and the original algorithm
+	DerivationSeed = "seed"
+
+	// DerivationHKDFSHA256v1 Passwords are derived from the master key, the
+	// kind and name of the item and the period index with HKDF-SHA256
+	DerivationHKDFSHA256v1 = "hkdf-sha256-v1"
+
+	// derivationSalt is the HKDF salt. It must never change as it would
+	// change every derived password
+	derivationSalt = "tokens2secrets"
+
+	// sealInfo and deriveInfo are the HKDF info of the subkeys for Seal and
+	// Open and for Derive
+	sealInfo   = "seal"
+	deriveInfo = "derive"
+
+	// sealPrefix marks a sealed value and the version of the format so that
+	// it may be changed in the future
+	sealPrefix = "sealed:v1:"
+)
+
+// Key Master key used to encrypt values at rest and to derive passwords. The
+// master key is not used directly. A subkey for sealing and a subkey for
+// derivation are derived from it so that the two uses are independent.
+type Key struct {
+	sealKey   []byte
+	deriveKey []byte
+}
+
+// Load Returns the master key from the key file or if file is empty from the
+// environment variable TOKENS2SECRETS_MASTER_KEY. The key is 32 random bytes
+// base64 encoded. Whitespace is ignored.
+func Load(file string) (*Key, error) {
+
+	var encoded string
+
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(content)
+	} else {
+		encoded = os.Getenv(EnvKey)
+	}
+
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, ErrNoKey
+	}
+
+	return Parse(encoded)
+}
+
+// Parse Returns the master key from the base64 encoded string
+func Parse(encoded string) (*Key, error) {
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+
+	sealKey, err := subkey(key, sealInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	deriveKey, err := subkey(key, deriveInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{sealKey: sealKey, deriveKey: deriveKey}, nil
+}
+
+// subkey returns the subkey of the master key for info
+func subkey(key []byte, info string) ([]byte, error) {
+	result := make([]byte, keySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, key, []byte(derivationSalt), []byte(info)), result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Generate Returns a new random master key base64 encoded
+func Generate() (string, error) {
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// IsSealed Returns true if the value was returned by Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealPrefix)
+}
+
+// Seal Encrypts the plaintext with AES-256-GCM. The context is authenticated
+// but not encrypted and the same context must be provided to Open. This binds
+// the value to where it is used (such as the name of a secret) so that it may
+// not be moved.
+func (t *Key) Seal(plaintext, context string) (string, error) {
+
+	aead, err := t.aead()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return sealPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open Decrypts a value returned by Seal
+func (t *Key) Open(value, context string) (string, error) {
+
+	if !IsSealed(value) {
+		return "", fmt.Errorf("Value is not sealed")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealPrefix))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+
+	aead, err := t.aead()
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(context))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+
+	return string(plaintext), nil
+}
+
+// Derive Returns length bytes derived from the derivation subkey for the item
+// of kind with name in the period with index using the HKDF-SHA256 v1
+// algorithm. The info is versioned so that a future algorithm may not collide.
+func (t *Key) Derive(kind, name string, index int64, length int) ([]byte, error) {
+
+	info := "v1/" + kind + "/" + name + "/" + strconv.FormatInt(index, 10)
+
+	result := make([]byte, length)
+	_, err := io.ReadFull(hkdf.New(sha256.New, t.deriveKey, []byte(derivationSalt), []byte(info)), result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ValidateDerivation Returns an error if the derivation is not known. An
+// empty derivation is DerivationSeed.
+func ValidateDerivation(derivation string) error {
+	switch derivation {
+	case "", DerivationSeed, DerivationHKDFSHA256v1:
+		return nil
+	}
+	return fmt.Errorf("Derivation %s is not supported. Must be %s or %s", derivation, DerivationSeed, DerivationHKDFSHA256v1)
+}
+
+func (t *Key) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(t.sealKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package masterkey
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+
+	encoded, err := Generate()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "masterkey")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "key")
+	ioutil.WriteFile(file, []byte(encoded+"\n"), 0600)
+
+	key, err := Load(file)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	sealed, err := key.Seal("password", "secret1")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if !IsSealed(sealed) || IsSealed("password") {
+		t.Fatalf("IsSealed is wrong")
+	}
+
+	plaintext, err := key.Open(sealed, "secret1")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if plaintext != "password" {
+		t.Fatalf("Expected password, got %s", plaintext)
+	}
+
+	// The value may not be moved to another context
+	if _, err := key.Open(sealed, "secret2"); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt")
+	}
+
+	other, _ := Generate()
+	otherKey, _ := Parse(other)
+	if _, err := otherKey.Open(sealed, "secret1"); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt")
+	}
+
+	os.Setenv(EnvKey, "")
+	if _, err := Load(""); err != ErrNoKey {
+		t.Fatalf("Expected ErrNoKey")
+	}
+
+	if _, err := Parse("c2hvcnQ="); err != ErrInvalidKey {
+		t.Fatalf("Expected ErrInvalidKey")
+	}
+
+}
+
+func TestDerive(t *testing.T) {
+
+	key, _ := Parse("AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")
+
+	a, err := key.Derive("secret", "secret1", 100, 32)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// The derivation must never change for a version as it would change
+	// every derived password
+	if hex.EncodeToString(a) != "c9bb17fbaf06ece3f2b6d137efc826e9adf24377b3957c0c0bbac39f234b3771" {
+		t.Fatalf("Unexpected derivation %x", a)
+	}
+
+	// The sealing and derivation subkeys are independent of each other and
+	// of the master key
+	master, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if string(key.sealKey) == string(key.deriveKey) || string(key.sealKey) == string(master) || string(key.deriveKey) == string(master) {
+		t.Fatalf("Expected independent subkeys")
+	}
+
+	b, _ := key.Derive("secret", "secret1", 101, 32)
+	c, _ := key.Derive("keytab", "secret1", 100, 32)
+
+	if hex.EncodeToString(a) == hex.EncodeToString(b) || hex.EncodeToString(a) == hex.EncodeToString(c) {
+		t.Fatalf("Expected derivation to differ by period and kind")
+	}
+
+	if ValidateDerivation(DerivationHKDFSHA256v1) != nil || ValidateDerivation("") != nil || ValidateDerivation("md5") == nil {
+		t.Fatalf("ValidateDerivation is wrong")
+	}
+
+}
@@ -22,6 +22,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
 	"go.uber.org/zap"
 )
 
@@ -33,9 +34,26 @@ const (
 	defaultLifetime             = time.Duration(60) * time.Second
 )
 
-// Config Config
+const (
+	// ModeLocal Nonces are kept by the instance that issued them (default)
+	ModeLocal = "local"
+
+	// ModeSigned Nonces are signed with a key shared by the instances
+	ModeSigned = "signed"
+)
+
+// Config Config. Clock is the source of time. Default is the system clock.
+// If Key is set then nonces are signed with it instead of being kept in the
+// Cache. Any instance with the same Key is then able to validate a nonce
+// issued by another. The Key should be at least 32 bytes. If SingleUse is
+// true then a nonce may only be consumed once. SingleUse is not supported
+// with a Key as any instance accepts a signed nonce but only the one that
+// consumed it would know.
 type Config struct {
 	CacheRefreshInterval, Lifetime time.Duration
+	Clock                          clock.Clock
+	Key                            []byte
+	SingleUse                      bool
 }
 
 // Cache Manages nonces. For our purposes a nonce is defined as a random
@@ -43,15 +61,21 @@ type Config struct {
 // and returned along with the expiration time to the caller. This allows
 // the caller to hand the nonce to a remote party. The remote party can then
 // present the nonce back in the future (before the expiration time is reached)
-// and the nonce can be validated that it originated with us.
+// and the nonce can be validated that it originated with us. Signed nonces
+// carry their expiration time and are validated by their signature so they
+// are not kept.
 type Cache struct {
 	mutex      sync.RWMutex
 	internal   map[string]*Nonce
+	used       map[string]int64
 	closed     chan struct{}
-	ticker     *time.Ticker
+	ticker     clock.Ticker
+	clock      clock.Clock
 	wg         sync.WaitGroup
 	seededRand *rand.Rand
-	lifetime   int64
+	lifetime   time.Duration
+	key        []byte
+	singleUse  bool
 }
 
 // Build Returns a new Cache
@@ -59,6 +83,10 @@ func (config *Config) Build() (*Cache, error) {
 
 	zap.L().Debug("Starting")
 
+	if len(config.Key) > 0 && config.SingleUse {
+		return nil, fmt.Errorf("SingleUse is not supported with Key")
+	}
+
 	cacheRefreshInterval := defaultCacheRefreshInterval
 	lifetime := defaultLifetime
 
@@ -70,12 +98,21 @@ func (config *Config) Build() (*Cache, error) {
 		lifetime = config.Lifetime
 	}
 
+	timeSource := clock.Real()
+	if config.Clock != nil {
+		timeSource = config.Clock
+	}
+
 	t := &Cache{
-		internal: make(map[string]*Nonce),
-		closed:   make(chan struct{}),
-		ticker:   time.NewTicker(cacheRefreshInterval),
-		wg:       sync.WaitGroup{},
-		lifetime: int64(lifetime),
+		internal:  make(map[string]*Nonce),
+		used:      make(map[string]int64),
+		closed:    make(chan struct{}),
+		ticker:    timeSource.NewTicker(cacheRefreshInterval),
+		clock:     timeSource,
+		wg:        sync.WaitGroup{},
+		lifetime:  lifetime,
+		key:       config.Key,
+		singleUse: config.SingleUse,
 		seededRand: rand.New(
 			rand.NewSource(time.Now().Unix())),
 	}
@@ -85,9 +122,10 @@ func (config *Config) Build() (*Cache, error) {
 		for {
 			select {
 			case <-t.closed:
+				t.ticker.Stop()
 				t.wg.Done()
 				return
-			case <-t.ticker.C:
+			case <-t.ticker.C():
 				t.processCache()
 
 			}
@@ -107,7 +145,7 @@ func (t *Cache) processCache() {
 
 	for key, e := range t.internal {
 
-		if time.Now().Unix() > e.Exp {
+		if t.clock.Now().Unix() > e.Exp {
 			removes = append(removes, key)
 			zap.L().Info(fmt.Sprintf("Ejecting->%s", e.JSON()))
 		} else {
@@ -121,12 +159,30 @@ func (t *Cache) processCache() {
 		}
 	}
 
+	// Once expired a consumed nonce is rejected as expired so it no longer
+	// needs to be kept
+	for key, exp := range t.used {
+		if t.clock.Now().Unix() > exp {
+			delete(t.used, key)
+		}
+	}
+
 	zap.L().Debug("Processing cache completed")
 
 }
 
-// NewNonce Returns a new nonce
+// NewNonce Returns a new nonce that is not bound
 func (t *Cache) NewNonce() (*Nonce, error) {
+	return t.NewBoundNonce(nil)
+}
+
+// NewBoundNonce Returns a new nonce bound to the identity of the requester.
+// The binding is kept with the nonce or for signed nonces carried in it.
+func (t *Cache) NewBoundNonce(binding map[string]string) (*Nonce, error) {
+
+	if len(t.key) > 0 {
+		return t.newSignedNonce(binding)
+	}
 
 	b := make([]byte, 64)
 	for i := range b {
@@ -134,8 +190,9 @@ func (t *Cache) NewNonce() (*Nonce, error) {
 	}
 
 	nonce := &Nonce{
-		Exp:   time.Now().Unix() + t.lifetime,
-		Value: string(b),
+		Exp:     t.clock.Now().Add(t.lifetime).Unix(),
+		Value:   string(b),
+		Binding: binding,
 	}
 
 	t.mutex.Lock()
@@ -155,9 +212,74 @@ func (t *Cache) GetNonce(key string) (*Nonce, error) {
 		return nil, ErrNotFound
 	}
 
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.getNonce(key)
+}
+
+// GetBoundNonce returns nonce if found, not expired and bound to the provided
+// binding. If the nonce is bound to a different identity ErrBinding is
+// returned.
+func (t *Cache) GetBoundNonce(key string, binding map[string]string) (*Nonce, error) {
+
+	nonce, err := t.GetNonce(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !nonce.Matches(binding) {
+		zap.L().Warn(fmt.Sprintf("Nonce binding mismatch; nonce key:%s, binding:%s, expected:%s", key, binding, nonce.Binding))
+		return nil, ErrBinding
+	}
+
+	return nonce, nil
+}
+
+// Consume Consumes the nonce if the Cache is single use. After it has been
+// consumed the nonce is rejected with ErrReplay. This should be called once
+// the request the nonce was presented for has succeeded.
+func (t *Cache) Consume(key string) error {
+
+	if !t.singleUse {
+		return nil
+	}
+
+	if key == "" {
+		return ErrNotFound
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	nonce, err := t.getNonce(key)
+	if err != nil {
+		return err
+	}
+
+	t.used[key] = nonce.Exp
+	delete(t.internal, key)
+
+	zap.L().Debug(fmt.Sprintf("Nonce consumed; nonce key:%s", key))
+	return nil
+}
+
+// getNonce returns nonce if found, not expired and not consumed. Must have
+// map locked!
+func (t *Cache) getNonce(key string) (*Nonce, error) {
+
+	if _, used := t.used[key]; used {
+		zap.L().Warn(fmt.Sprintf("Nonce replay rejected; nonce key:%s", key))
+		return nil, ErrReplay
+	}
+
+	if len(t.key) > 0 {
+		return t.getSignedNonce(key)
+	}
+
 	nonce, exist := t.internal[key]
 	if exist {
-		if time.Now().Unix() > nonce.Exp {
+		if t.clock.Now().Unix() > nonce.Exp {
 			zap.L().Debug(fmt.Sprintf("Nonce expired; nonce key:%s", key))
 			return nil, ErrExpired
 		}
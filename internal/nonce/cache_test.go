@@ -19,15 +19,20 @@ package nonce
 import (
 	"testing"
 	"time"
+
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
 )
 
 func Test1(t *testing.T) {
 
 	var err error
 
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC))
+
 	config := &Config{
-		CacheRefreshInterval: 5,
-		Lifetime:             5,
+		CacheRefreshInterval: 5 * time.Second,
+		Lifetime:             5 * time.Second,
+		Clock:                fake,
 	}
 
 	nonces, err := config.Build()
@@ -64,7 +69,7 @@ func Test1(t *testing.T) {
 		t.Fatalf("Unexpected")
 	}
 
-	time.Sleep(2 * time.Second)
+	fake.Advance(2 * time.Second)
 
 	if _, err := nonces.GetNonce(nonce1.Value); err != nil {
 		t.Fatalf("Unexpected")
@@ -78,7 +83,7 @@ func Test1(t *testing.T) {
 		t.Fatalf("Unexpected")
 	}
 
-	time.Sleep(6 * time.Second)
+	fake.Advance(6 * time.Second)
 
 	if _, err := nonces.GetNonce(nonce1.Value); err == nil {
 		t.Fatalf("Unexpected")
@@ -119,3 +124,177 @@ func Test1(t *testing.T) {
 	// }
 
 }
+
+func TestSigned(t *testing.T) {
+
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC))
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	a, err := (&Config{Lifetime: time.Minute, Clock: fake, Key: key}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer a.Shutdown()
+
+	b, err := (&Config{Lifetime: time.Minute, Clock: fake, Key: key}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer b.Shutdown()
+
+	other, err := (&Config{Lifetime: time.Minute, Clock: fake, Key: []byte("fedcba9876543210fedcba9876543210")}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer other.Shutdown()
+
+	nonce, err := a.NewNonce()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if nonce.Exp != fake.Now().Add(time.Minute).Unix() {
+		t.Fatalf("Unexpected exp %d", nonce.Exp)
+	}
+
+	// Any instance with the same key validates the nonce
+	result, err := b.GetNonce(nonce.Value)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if result.Exp != nonce.Exp {
+		t.Fatalf("Expected exp %d, got %d", nonce.Exp, result.Exp)
+	}
+
+	if _, err := other.GetNonce(nonce.Value); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for other key, got %v", err)
+	}
+
+	// Changing the expiration invalidates the signature
+	tampered := []byte(nonce.Value)
+	tampered[4] = tampered[4] ^ 1
+	if _, err := b.GetNonce(string(tampered)); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for tampered nonce, got %v", err)
+	}
+
+	if _, err := b.GetNonce("invalid"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	fake.Advance(61 * time.Second)
+
+	if _, err := b.GetNonce(nonce.Value); err != ErrExpired {
+		t.Fatalf("Expected ErrExpired, got %v", err)
+	}
+
+}
+
+func TestSingleUse(t *testing.T) {
+
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC))
+
+	nonces, err := (&Config{Lifetime: time.Minute, Clock: fake, SingleUse: true}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer nonces.Shutdown()
+
+	nonce, _ := nonces.NewNonce()
+
+	// The nonce may be validated any number of times until consumed
+	if _, err := nonces.GetNonce(nonce.Value); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if err := nonces.Consume(nonce.Value); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if _, err := nonces.GetNonce(nonce.Value); err != ErrReplay {
+		t.Fatalf("Expected ErrReplay, got %v", err)
+	}
+
+	if err := nonces.Consume(nonce.Value); err != ErrReplay {
+		t.Fatalf("Expected ErrReplay, got %v", err)
+	}
+
+	if err := nonces.Consume("invalid"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Consumed nonces are forgotten once expired
+	fake.Advance(61 * time.Second)
+	nonces.processCache()
+
+	if len(nonces.used) != 0 {
+		t.Fatalf("Expected consumed nonce to be removed after expiration")
+	}
+
+	// Signed nonces can not be single use
+	if _, err := (&Config{Clock: fake, Key: []byte("0123456789abcdef0123456789abcdef"), SingleUse: true}).Build(); err == nil {
+		t.Fatalf("Expected error for SingleUse with Key")
+	}
+
+	// Without single use consuming does nothing
+	other, _ := (&Config{Clock: fake}).Build()
+	defer other.Shutdown()
+
+	nonce, _ = other.NewNonce()
+	other.Consume(nonce.Value)
+	if _, err := other.GetNonce(nonce.Value); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+}
+
+func TestBinding(t *testing.T) {
+
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 10, 0, 0, time.UTC))
+
+	binding := map[string]string{"iss": "https://example.com", "sub": "alice"}
+
+	for _, key := range [][]byte{nil, []byte("0123456789abcdef0123456789abcdef")} {
+
+		nonces, err := (&Config{Lifetime: time.Minute, Clock: fake, Key: key}).Build()
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+
+		nonce, err := nonces.NewBoundNonce(binding)
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+
+		result, err := nonces.GetBoundNonce(nonce.Value, map[string]string{"iss": "https://example.com", "sub": "alice"})
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+
+		if result.Binding["sub"] != "alice" || result.Binding["iss"] != "https://example.com" {
+			t.Fatalf("Unexpected binding %s", result.Binding)
+		}
+
+		if _, err := nonces.GetBoundNonce(nonce.Value, map[string]string{"iss": "https://example.com", "sub": "bob"}); err != ErrBinding {
+			t.Fatalf("Expected ErrBinding, got %v", err)
+		}
+
+		if _, err := nonces.GetBoundNonce(nonce.Value, nil); err != ErrBinding {
+			t.Fatalf("Expected ErrBinding, got %v", err)
+		}
+
+		// A nonce that is not bound only matches an empty binding
+		unbound, _ := nonces.NewNonce()
+
+		if _, err := nonces.GetBoundNonce(unbound.Value, nil); err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+
+		if _, err := nonces.GetBoundNonce(unbound.Value, binding); err != ErrBinding {
+			t.Fatalf("Expected ErrBinding, got %v", err)
+		}
+
+		nonces.Shutdown()
+	}
+
+}
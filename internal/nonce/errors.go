@@ -24,4 +24,10 @@ var (
 
 	// ErrExpired Nonce is expired
 	ErrExpired error = errors.New("Nonce is expired")
+
+	// ErrReplay Nonce has already been used
+	ErrReplay error = errors.New("Nonce has already been used")
+
+	// ErrBinding Nonce is bound to a different identity
+	ErrBinding error = errors.New("Nonce is bound to a different identity")
 )
@@ -22,10 +22,28 @@ import (
 	"github.com/jinzhu/copier"
 )
 
-// Nonce holds one time expiring secret
+// Nonce holds one time expiring secret. Binding is the identity of the
+// requester the nonce was issued to. A nonce without a Binding is not bound.
 type Nonce struct {
-	Exp   int64  `json:"exp,omitempty" yaml:"exp,omitempty"`
-	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	Exp     int64             `json:"exp,omitempty" yaml:"exp,omitempty"`
+	Value   string            `json:"value,omitempty" yaml:"value,omitempty"`
+	Binding map[string]string `json:"binding,omitempty" yaml:"binding,omitempty"`
+}
+
+// Matches returns true if the binding is the same as the nonce Binding
+func (t *Nonce) Matches(binding map[string]string) bool {
+
+	if len(t.Binding) != len(binding) {
+		return false
+	}
+
+	for key, value := range t.Binding {
+		if other, exist := binding[key]; !exist || other != value {
+			return false
+		}
+	}
+
+	return true
 }
 
 // JSON Return JSON String representation
@@ -38,5 +56,11 @@ func (t *Nonce) JSON() string {
 func (t *Nonce) Copy() *Nonce {
 	clone := &Nonce{}
 	copier.Copy(&clone, &t)
+	if t.Binding != nil {
+		clone.Binding = make(map[string]string, len(t.Binding))
+		for key, value := range t.Binding {
+			clone.Binding[key] = value
+		}
+	}
 	return clone
 }
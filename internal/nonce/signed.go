@@ -0,0 +1,118 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nonce
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// A signed nonce is the version, the expiration time, random bytes, the
+// binding as JSON (if any) and the HMAC-SHA256 of these with the Key. It is
+// encoded with base64url so that it may be used as the audience of a token.
+// The binding is signed but not encrypted so the bound claim values are
+// readable by anyone holding the nonce.
+const (
+	signedVersion    = 1
+	signedRandomSize = 16
+	signedDataSize   = 1 + 8 + signedRandomSize
+	signedSize       = signedDataSize + sha256.Size
+)
+
+func (t *Cache) newSignedNonce(binding map[string]string) (*Nonce, error) {
+
+	exp := t.clock.Now().Add(t.lifetime).Unix()
+
+	var encodedBinding []byte
+	if len(binding) > 0 {
+		var err error
+		encodedBinding, err = json.Marshal(binding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b := make([]byte, signedDataSize, signedSize+len(encodedBinding))
+	b[0] = signedVersion
+	binary.BigEndian.PutUint64(b[1:9], uint64(exp))
+
+	_, err := rand.Read(b[9:])
+	if err != nil {
+		return nil, err
+	}
+
+	b = append(b, encodedBinding...)
+	b = append(b, t.sign(b)...)
+
+	return &Nonce{
+		Exp:     exp,
+		Value:   base64.RawURLEncoding.EncodeToString(b),
+		Binding: binding,
+	}, nil
+}
+
+// getSignedNonce returns the nonce if the signature is valid and it is not
+// expired. A nonce with an invalid signature is treated as not found.
+func (t *Cache) getSignedNonce(key string) (*Nonce, error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(b) < signedSize || b[0] != signedVersion {
+		zap.L().Debug(fmt.Sprintf("Nonce is not a signed nonce; nonce key:%s", key))
+		return nil, ErrNotFound
+	}
+
+	data := b[:len(b)-sha256.Size]
+
+	if !hmac.Equal(b[len(data):], t.sign(data)) {
+		zap.L().Debug(fmt.Sprintf("Nonce signature is invalid; nonce key:%s", key))
+		return nil, ErrNotFound
+	}
+
+	nonce := &Nonce{
+		Exp:   int64(binary.BigEndian.Uint64(b[1:9])),
+		Value: key,
+	}
+
+	if len(data) > signedDataSize {
+		err = json.Unmarshal(data[signedDataSize:], &nonce.Binding)
+		if err != nil {
+			zap.L().Debug(fmt.Sprintf("Nonce binding is invalid; nonce key:%s", key))
+			return nil, ErrNotFound
+		}
+	}
+
+	if t.clock.Now().Unix() > nonce.Exp {
+		zap.L().Debug(fmt.Sprintf("Nonce expired; nonce key:%s", key))
+		return nil, ErrExpired
+	}
+
+	zap.L().Debug(fmt.Sprintf("Nonce signature valid and not expired; nonce key:%s", key))
+	return nonce, nil
+}
+
+func (t *Cache) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
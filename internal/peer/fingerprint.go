@@ -0,0 +1,77 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const fingerprintLength = 16
+
+// Fingerprint of the password for a keytab or secret for a period. Instances
+// of the server that share the same seeds, clocks and peer token have the
+// same fingerprints. The fingerprint is a truncated HMAC of the password keyed
+// with a key derived from the peer token. Without the key a fingerprint can
+// not be used to guess the password offline.
+type Fingerprint struct {
+	Kind        string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
+	Epoch       int64  `json:"epoch,omitempty" yaml:"epoch,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+}
+
+// Fingerprints Fingerprints of an instance
+type Fingerprints struct {
+	Fingerprints []*Fingerprint `json:"fingerprints,omitempty" yaml:"fingerprints,omitempty"`
+}
+
+// Source Interface. A Source returns the fingerprints for the current periods
+// with the provided key
+type Source interface {
+	GetFingerprints(now time.Time, key []byte) []*Fingerprint
+}
+
+// NewFingerprint Returns the Fingerprint of the password with the key
+func NewFingerprint(key []byte, kind, name string, epoch int64, password string) *Fingerprint {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fmt.Sprintf("%s:%s:%d:", kind, name, epoch)))
+	mac.Write([]byte(password))
+	return &Fingerprint{
+		Kind:        kind,
+		Name:        name,
+		Epoch:       epoch,
+		Fingerprint: hex.EncodeToString(mac.Sum(nil))[:fingerprintLength],
+	}
+}
+
+// fingerprintKey Returns the fingerprint key derived from the peer token
+func fingerprintKey(token string) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("fingerprint"))
+	return mac.Sum(nil)
+}
+
+// JSON Return JSON String representation
+func (t *Fingerprints) JSON() string {
+	j, _ := json.Marshal(t)
+	return string(j)
+}
@@ -0,0 +1,294 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jodydadescott/tokens2secrets/internal/event"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultInterval = time.Duration(1) * time.Minute
+	defaultTimeout  = time.Duration(10) * time.Second
+)
+
+// Config Configuration
+//
+// Peers: Zero or more base URLs of the other instances of the server
+//
+// Token: Shared token the instances use to get each others fingerprints. The
+// fingerprints are not served if the Token is not set. The fingerprints are
+// keyed with a key derived from the Token
+//
+// AllowHTTP: Permit peers with http URLs. By default peers must use https as
+// the Token and fingerprints are sent to them
+//
+// Interval: How often the peers are checked. Default is one minute
+//
+// Sources: Sources of the local fingerprints
+//
+// Events: Optional Publisher that divergence events are sent to
+type Config struct {
+	Peers     []string
+	Token     string
+	Interval  time.Duration
+	Sources   []Source
+	Events    *event.Publisher
+	AllowHTTP bool
+}
+
+// Health Result of the last check of a peer. Diverged are the keytabs and
+// secrets (as kind/name) that have a different password on the peer.
+type Health struct {
+	Peer      string   `json:"peer,omitempty" yaml:"peer,omitempty"`
+	LastCheck int64    `json:"lastCheck,omitempty" yaml:"lastCheck,omitempty"`
+	LastError string   `json:"lastError,omitempty" yaml:"lastError,omitempty"`
+	Compared  int      `json:"compared,omitempty" yaml:"compared,omitempty"`
+	Diverged  []string `json:"diverged,omitempty" yaml:"diverged,omitempty"`
+}
+
+// Verifier periodically compares the local fingerprints with those of each
+// peer. This replaces comparing password hashes in the debug logs of each
+// instance by hand. When a password differs an error is logged and an event
+// is published.
+type Verifier struct {
+	peers    []string
+	token    string
+	interval time.Duration
+	sources  []Source
+	events   *event.Publisher
+	client   *http.Client
+	mutex    sync.RWMutex
+	health   map[string]*Health
+	closed   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Build Returns a new Verifier
+func (config *Config) Build() (*Verifier, error) {
+
+	zap.L().Debug("Starting")
+
+	interval := defaultInterval
+	if config.Interval > 0 {
+		interval = config.Interval
+	}
+
+	t := &Verifier{
+		token:    config.Token,
+		interval: interval,
+		sources:  config.Sources,
+		events:   config.Events,
+		client:   &http.Client{Timeout: defaultTimeout},
+		health:   make(map[string]*Health),
+		closed:   make(chan struct{}),
+	}
+
+	for _, peer := range config.Peers {
+		if strings.HasPrefix(peer, "http://") {
+			if !config.AllowHTTP {
+				return nil, fmt.Errorf("Peer %s must be a https URL unless http is allowed", peer)
+			}
+			zap.L().Warn(fmt.Sprintf("Peer %s uses http; the peer token and fingerprints are sent in the clear", peer))
+		} else if !strings.HasPrefix(peer, "https://") {
+			return nil, fmt.Errorf("Peer %s must be a https URL", peer)
+		}
+		t.peers = append(t.peers, strings.TrimSuffix(peer, "/"))
+	}
+
+	if len(t.peers) > 0 {
+		if t.token == "" {
+			return nil, fmt.Errorf("Peer token is required when peers are set")
+		}
+		t.wg.Add(1)
+		go t.run()
+	}
+
+	return t, nil
+}
+
+func (t *Verifier) run() {
+
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.closed:
+			return
+		case <-ticker.C:
+			t.verify()
+		}
+	}
+}
+
+// GetFingerprints Returns the local fingerprints if the token matches
+func (t *Verifier) GetFingerprints(token string) (*Fingerprints, error) {
+
+	err := t.Auth(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.local(getTime()), nil
+}
+
+// Auth Returns ErrDenied if the token does not match the peer token or the
+// peer token is not set
+func (t *Verifier) Auth(token string) error {
+	if t.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(t.token)) != 1 {
+		return ErrDenied
+	}
+	return nil
+}
+
+func (t *Verifier) local(now time.Time) *Fingerprints {
+	result := &Fingerprints{}
+	key := fingerprintKey(t.token)
+	for _, source := range t.sources {
+		result.Fingerprints = append(result.Fingerprints, source.GetFingerprints(now, key)...)
+	}
+	return result
+}
+
+// verify compares the local fingerprints with each peer
+func (t *Verifier) verify() {
+	for _, peer := range t.peers {
+		health := t.verifyPeer(peer)
+		t.mutex.Lock()
+		t.health[peer] = health
+		t.mutex.Unlock()
+	}
+}
+
+func (t *Verifier) verifyPeer(peer string) *Health {
+
+	health := &Health{
+		Peer:      peer,
+		LastCheck: getTime().Unix(),
+	}
+
+	remote, err := t.fetch(peer)
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("Unable to get fingerprints from peer %s; err->%s", peer, err.Error()))
+		health.LastError = err.Error()
+		return health
+	}
+
+	local := t.local(getTime())
+
+	remoteMap := make(map[string]*Fingerprint)
+	for _, fingerprint := range remote.Fingerprints {
+		remoteMap[fingerprint.Kind+"/"+fingerprint.Name] = fingerprint
+	}
+
+	for _, fingerprint := range local.Fingerprints {
+
+		key := fingerprint.Kind + "/" + fingerprint.Name
+
+		other, exist := remoteMap[key]
+
+		// Entities that are not on both instances or that are in different
+		// periods (the check happened at a rotation) can not be compared
+		if !exist || other.Epoch != fingerprint.Epoch {
+			continue
+		}
+
+		health.Compared++
+
+		if other.Fingerprint == fingerprint.Fingerprint {
+			continue
+		}
+
+		health.Diverged = append(health.Diverged, key)
+
+		zap.L().Error(fmt.Sprintf("Password for %s differs from peer %s; check that the seeds and clocks match", key, peer))
+
+		t.events.Publish(&event.Event{
+			Type:  event.TypeDiverged,
+			Kind:  fingerprint.Kind,
+			Name:  fingerprint.Name,
+			Error: fmt.Sprintf("Password differs from peer %s", peer),
+		})
+	}
+
+	zap.L().Debug(fmt.Sprintf("Verified peer %s; compared=%d, diverged=%d", peer, health.Compared, len(health.Diverged)))
+	return health
+}
+
+func (t *Verifier) fetch(peer string) (*Fingerprints, error) {
+
+	req, err := http.NewRequest(http.MethodGet, peer+"/getfingerprints", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+t.token)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Peer returned status %d", resp.StatusCode)
+	}
+
+	result := &Fingerprints{}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetHealth Returns the result of the last check of each peer
+func (t *Verifier) GetHealth() []*Health {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	var result []*Health
+	for _, peer := range t.peers {
+		if health, exist := t.health[peer]; exist {
+			result = append(result, health)
+		}
+	}
+	return result
+}
+
+func getTime() time.Time {
+	// If running multiple instance the time must be the same so we statically use UTC
+	return time.Now().In(time.UTC)
+}
+
+// Shutdown Verifier
+func (t *Verifier) Shutdown() {
+	zap.L().Debug("Stopping")
+	close(t.closed)
+	t.wg.Wait()
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testKey = fingerprintKey("peertoken")
+
+type staticSource struct {
+	fingerprints []*Fingerprint
+}
+
+func (t *staticSource) GetFingerprints(now time.Time, key []byte) []*Fingerprint {
+	return t.fingerprints
+}
+
+func TestVerifier(t *testing.T) {
+
+	remote := &Verifier{
+		token: "peertoken",
+		sources: []Source{&staticSource{fingerprints: []*Fingerprint{
+			NewFingerprint(testKey, "keytab", "bob@EXAMPLE.COM", 3600, "password1"),
+			NewFingerprint(testKey, "keytab", "alice@EXAMPLE.COM", 3600, "other"),
+			NewFingerprint(testKey, "secret", "secret1", 7200, "password3"),
+		}}},
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := remote.GetFingerprints(r.Header.Get("Authorization")[len("Bearer "):])
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	local := &Config{
+		Peers:    []string{server.URL + "/"},
+		Token:    "peertoken",
+		Interval: time.Hour,
+		Sources: []Source{&staticSource{fingerprints: []*Fingerprint{
+			NewFingerprint(testKey, "keytab", "bob@EXAMPLE.COM", 3600, "password1"),
+			NewFingerprint(testKey, "keytab", "alice@EXAMPLE.COM", 3600, "password2"),
+			NewFingerprint(testKey, "secret", "secret1", 3600, "password3"),
+			NewFingerprint(testKey, "keytab", "carol@EXAMPLE.COM", 3600, "password4"),
+		}}},
+	}
+
+	verifier, err := local.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer verifier.Shutdown()
+
+	// Trust the certificate of the test server
+	verifier.client = server.Client()
+
+	verifier.verify()
+
+	health := verifier.GetHealth()
+	if len(health) != 1 {
+		t.Fatalf("Expected health for 1 peer, got %d", len(health))
+	}
+
+	// secret1 is in a different period and carol is not on the peer
+	if health[0].LastError != "" || health[0].Compared != 2 {
+		t.Fatalf("Unexpected health %+v", health[0])
+	}
+
+	if len(health[0].Diverged) != 1 || health[0].Diverged[0] != "keytab/alice@EXAMPLE.COM" {
+		t.Fatalf("Expected alice to diverge, got %s", health[0].Diverged)
+	}
+
+	// A peer with a different token is an error
+	verifier.token = "wrong"
+	verifier.verify()
+	if verifier.GetHealth()[0].LastError == "" {
+		t.Fatalf("Expected error for wrong token")
+	}
+
+	if _, err := remote.GetFingerprints(""); err != ErrDenied {
+		t.Fatalf("Expected ErrDenied, got %v", err)
+	}
+
+	if _, err := (&Config{Peers: []string{server.URL}}).Build(); err == nil {
+		t.Fatalf("Expected error for peers without token")
+	}
+
+	// Peers must use https unless http is allowed
+	if _, err := (&Config{Peers: []string{"http://peer.example.com"}, Token: "peertoken"}).Build(); err == nil {
+		t.Fatalf("Expected error for http peer")
+	}
+
+	insecure, err := (&Config{Peers: []string{"http://peer.example.com"}, Token: "peertoken", Interval: time.Hour, AllowHTTP: true}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	insecure.Shutdown()
+
+}
+
+func TestFingerprint(t *testing.T) {
+
+	a := NewFingerprint(testKey, "keytab", "bob@EXAMPLE.COM", 3600, "password")
+
+	if a.Fingerprint != NewFingerprint(testKey, "keytab", "bob@EXAMPLE.COM", 3600, "password").Fingerprint {
+		t.Fatalf("Expected fingerprint to be deterministic")
+	}
+
+	if a.Fingerprint == NewFingerprint(testKey, "keytab", "bob@EXAMPLE.COM", 7200, "password").Fingerprint {
+		t.Fatalf("Expected fingerprint to depend on the period")
+	}
+
+	// Without the peer token the fingerprint can not be reproduced
+	if a.Fingerprint == NewFingerprint(fingerprintKey("other"), "keytab", "bob@EXAMPLE.COM", 3600, "password").Fingerprint {
+		t.Fatalf("Expected fingerprint to depend on the key")
+	}
+
+	if len(a.Fingerprint) != fingerprintLength {
+		t.Fatalf("Expected fingerprint length %d, got %d", fingerprintLength, len(a.Fingerprint))
+	}
+
+}
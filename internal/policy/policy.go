@@ -18,8 +18,11 @@ package policy
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
+	"github.com/jodydadescott/tokens2secrets/internal/certificate"
+	"github.com/jodydadescott/tokens2secrets/internal/sshcert"
 	"github.com/open-policy-agent/opa/rego"
 	"go.uber.org/zap"
 )
@@ -31,7 +34,9 @@ type Config struct {
 
 // Policy ...
 type Policy struct {
-	query rego.PreparedEvalQuery
+	query               rego.PreparedEvalQuery
+	certificateQuery    rego.PreparedEvalQuery
+	sshCertificateQuery rego.PreparedEvalQuery
 }
 
 // Build ...
@@ -52,8 +57,30 @@ func (config *Config) Build() (*Policy, error) {
 		return nil, err
 	}
 
+	// The certificate rules are evaluated with their own query so that
+	// policies that do not define them continue to work for the other rules
+	certificateQuery, err := rego.New(
+		rego.Query("auth_get_certificate = data.main.auth_get_certificate"),
+		rego.Module("kerberos.rego", config.Policy),
+	).PrepareForEval(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sshCertificateQuery, err := rego.New(
+		rego.Query("auth_get_ssh_certificate = data.main.auth_get_ssh_certificate"),
+		rego.Module("kerberos.rego", config.Policy),
+	).PrepareForEval(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &Policy{
-		query: query,
+		query:               query,
+		certificateQuery:    certificateQuery,
+		sshCertificateQuery: sshCertificateQuery,
 	}, nil
 }
 
@@ -88,11 +115,12 @@ func (t *Policy) AuthGetNonce(ctx context.Context, claims map[string]interface{}
 }
 
 // AuthGetKeytab Auth that claims, nonce and principals are allowed to get requested keytab
-func (t *Policy) AuthGetKeytab(ctx context.Context, claims map[string]interface{}, nonce, principal string) error {
+func (t *Policy) AuthGetKeytab(ctx context.Context, claims map[string]interface{}, nonce string, binding map[string]string, principal string) error {
 
 	input := &Input{
 		Claims:    claims,
 		Nonce:     nonce,
+		Binding:   binding,
 		Principal: principal,
 	}
 
@@ -120,12 +148,13 @@ func (t *Policy) AuthGetKeytab(ctx context.Context, claims map[string]interface{
 }
 
 // AuthGetSecret Auth request for secret
-func (t *Policy) AuthGetSecret(ctx context.Context, claims map[string]interface{}, nonce, name string) error {
+func (t *Policy) AuthGetSecret(ctx context.Context, claims map[string]interface{}, nonce string, binding map[string]string, name string) error {
 
 	input := &Input{
-		Claims: claims,
-		Nonce:  nonce,
-		Secret: name,
+		Claims:  claims,
+		Nonce:   nonce,
+		Binding: binding,
+		Secret:  name,
 	}
 
 	results, err := t.query.Eval(ctx, rego.EvalInput(input))
@@ -150,3 +179,121 @@ func (t *Policy) AuthGetSecret(ctx context.Context, claims map[string]interface{
 	zap.L().Error(fmt.Sprintf("Unexpected error on Rego policy execution; unexpected result type"))
 	return ErrInvalidType
 }
+
+// AuthGetCertificate Auth request for certificate. The rule may return true
+// in which case the names in the request are allowed as is or it may return
+// an object with the names and max lifetime the certificate is constrained
+// to. If the policy does not define the rule the request is denied.
+func (t *Policy) AuthGetCertificate(ctx context.Context, claims map[string]interface{}, nonce string, binding map[string]string, request *certificate.Request) (*certificate.Constraints, error) {
+
+	input := &Input{
+		Claims:      claims,
+		Nonce:       nonce,
+		Binding:     binding,
+		Certificate: request,
+	}
+
+	results, err := t.certificateQuery.Eval(ctx, rego.EvalInput(input))
+
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("Unexpected error on Rego policy execution; err->%s", err))
+		return nil, ErrUnexpected
+	}
+
+	if len(results) == 0 {
+		return nil, ErrDenied
+	}
+
+	switch auth := results[0].Bindings["auth_get_certificate"].(type) {
+
+	case bool:
+		if auth {
+			return &certificate.Constraints{
+				CommonNames: []string{request.CommonName},
+				DNSNames:    request.DNSNames,
+				IPAddresses: request.IPAddresses,
+				URIs:        request.URIs,
+				Emails:      request.Emails,
+			}, nil
+		}
+		return nil, ErrDenied
+
+	case map[string]interface{}:
+		constraints := &certificate.Constraints{}
+		err = decodeObject(auth, constraints)
+		if err != nil {
+			return nil, err
+		}
+		return constraints, nil
+
+	}
+
+	zap.L().Error(fmt.Sprintf("Unexpected error on Rego policy execution; unexpected result type"))
+	return nil, ErrInvalidType
+}
+
+// AuthGetSSHCertificate Auth request for SSH certificate. The rule may return
+// true in which case the requested principals are allowed as is or it may
+// return an object with the principals, max lifetime and extensions the
+// certificate is constrained to. If the policy does not define the rule the
+// request is denied.
+func (t *Policy) AuthGetSSHCertificate(ctx context.Context, claims map[string]interface{}, nonce string, binding map[string]string, request *sshcert.Request) (*sshcert.Constraints, error) {
+
+	input := &Input{
+		Claims:  claims,
+		Nonce:   nonce,
+		Binding: binding,
+		SSH:     request,
+	}
+
+	results, err := t.sshCertificateQuery.Eval(ctx, rego.EvalInput(input))
+
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("Unexpected error on Rego policy execution; err->%s", err))
+		return nil, ErrUnexpected
+	}
+
+	if len(results) == 0 {
+		return nil, ErrDenied
+	}
+
+	switch auth := results[0].Bindings["auth_get_ssh_certificate"].(type) {
+
+	case bool:
+		if auth {
+			return &sshcert.Constraints{
+				Principals: request.Principals,
+			}, nil
+		}
+		return nil, ErrDenied
+
+	case map[string]interface{}:
+		constraints := &sshcert.Constraints{}
+		err = decodeObject(auth, constraints)
+		if err != nil {
+			return nil, err
+		}
+		return constraints, nil
+
+	}
+
+	zap.L().Error(fmt.Sprintf("Unexpected error on Rego policy execution; unexpected result type"))
+	return nil, ErrInvalidType
+}
+
+// decodeObject decodes an object returned by the policy into v
+func decodeObject(object map[string]interface{}, v interface{}) error {
+
+	b, err := json.Marshal(object)
+	if err != nil {
+		return ErrInvalidType
+	}
+
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("Unexpected error on Rego policy execution; err->%s", err))
+		return ErrInvalidType
+	}
+
+	return nil
+}
@@ -21,6 +21,8 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/jodydadescott/tokens2secrets/internal/certificate"
+	"github.com/jodydadescott/tokens2secrets/internal/sshcert"
 	"github.com/open-policy-agent/opa/rego"
 )
 
@@ -66,6 +68,22 @@ auth_get_secret {
    auth_base
    auth_nonce
 }
+
+auth_get_certificate = {"dns_names": names, "max_lifetime": 600} {
+   # The certificate may only be issued for the DNS names in the claim
+   # service.certificate and for at most ten minutes
+   auth_base
+   auth_nonce
+   names := split(input.claims.service.certificate,",")
+}
+
+auth_get_ssh_certificate = {"principals": principals, "extensions": {"permit-pty": ""}} {
+   # User certificates may be issued for the principals in the claim service.ssh
+   auth_base
+   auth_nonce
+   input.ssh.type == "user"
+   principals := split(input.claims.service.ssh,",")
+}
 `
 
 	exampleInput = `
@@ -76,7 +94,9 @@ auth_get_secret {
 	"exp": 1599844897,
 	"aud": "drpepper",
 	"service": {
-	  "keytab": "user1@example.com,user2@example.com"
+	  "keytab": "user1@example.com,user2@example.com",
+	  "certificate": "www.example.com,*.api.example.com",
+	  "ssh": "user1,user2"
 	}
   }
 `
@@ -114,14 +134,80 @@ func Test1(t *testing.T) {
 		t.Errorf("AuthGetNonce should be true")
 	}
 
-	err = policy.AuthGetKeytab(ctx, claims, "drpepper", "user1@example.com")
+	err = policy.AuthGetKeytab(ctx, claims, "drpepper", nil, "user1@example.com")
 	if err != nil {
 		t.Errorf("AuthGetKeytab should be true")
 	}
 
-	err = policy.AuthGetSecret(ctx, claims, "drpepper", "secret1")
+	err = policy.AuthGetSecret(ctx, claims, "drpepper", nil, "secret1")
 	if err != nil {
 		t.Errorf("AuthGetSecret should be true")
 	}
 
 }
+
+func TestCertificate(t *testing.T) {
+
+	var claims map[string]interface{}
+	json.Unmarshal([]byte(exampleInput), &claims)
+
+	ctx := context.Background()
+
+	policy, err := (&Config{Policy: examplePolicy}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+
+	request := &certificate.Request{DNSNames: []string{"www.example.com"}}
+
+	constraints, err := policy.AuthGetCertificate(ctx, claims, "drpepper", nil, request)
+	if err != nil {
+		t.Fatalf("AuthGetCertificate should be true")
+	}
+
+	if len(constraints.DNSNames) != 2 || constraints.DNSNames[1] != "*.api.example.com" {
+		t.Fatalf("Unexpected DNS names %s", constraints.DNSNames)
+	}
+
+	if constraints.MaxLifetime != 600 {
+		t.Fatalf("Expected max lifetime 600, got %d", constraints.MaxLifetime)
+	}
+
+	_, err = policy.AuthGetCertificate(ctx, claims, "wrong", nil, request)
+	if err != ErrDenied {
+		t.Fatalf("AuthGetCertificate should be denied")
+	}
+
+}
+
+func TestSSHCertificate(t *testing.T) {
+
+	var claims map[string]interface{}
+	json.Unmarshal([]byte(exampleInput), &claims)
+
+	ctx := context.Background()
+
+	policy, err := (&Config{Policy: examplePolicy}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected error:%s", err)
+	}
+
+	constraints, err := policy.AuthGetSSHCertificate(ctx, claims, "drpepper", nil, &sshcert.Request{Type: sshcert.TypeUser})
+	if err != nil {
+		t.Fatalf("AuthGetSSHCertificate should be true")
+	}
+
+	if len(constraints.Principals) != 2 || constraints.Principals[0] != "user1" {
+		t.Fatalf("Unexpected principals %s", constraints.Principals)
+	}
+
+	if _, exist := constraints.Extensions["permit-pty"]; !exist || len(constraints.Extensions) != 1 {
+		t.Fatalf("Unexpected extensions %s", constraints.Extensions)
+	}
+
+	_, err = policy.AuthGetSSHCertificate(ctx, claims, "drpepper", nil, &sshcert.Request{Type: sshcert.TypeHost})
+	if err != ErrDenied {
+		t.Fatalf("AuthGetSSHCertificate should be denied for host")
+	}
+
+}
@@ -16,10 +16,20 @@ limitations under the License.
 
 package policy
 
-// Input Data structure sent to OPA / Rego for auth decision
+import (
+	"github.com/jodydadescott/tokens2secrets/internal/certificate"
+	"github.com/jodydadescott/tokens2secrets/internal/sshcert"
+)
+
+// Input Data structure sent to OPA / Rego for auth decision. Binding is the
+// identity the nonce was issued to.
 type Input struct {
-	Claims    interface{} `json:"claims,omitempty" yaml:"claims,omitempty"`
-	Nonce     string      `json:"nonce,omitempty" yaml:"nonce,omitempty"`
-	Principal string      `json:"principal,omitempty" yaml:"principal,omitempty"`
-	Secret    string      `json:"secret,omitempty" yaml:"secret,omitempty"`
+	Claims    interface{}       `json:"claims,omitempty" yaml:"claims,omitempty"`
+	Nonce     string            `json:"nonce,omitempty" yaml:"nonce,omitempty"`
+	Binding   map[string]string `json:"binding,omitempty" yaml:"binding,omitempty"`
+	Principal string            `json:"principal,omitempty" yaml:"principal,omitempty"`
+	Secret    string            `json:"secret,omitempty" yaml:"secret,omitempty"`
+
+	Certificate *certificate.Request `json:"certificate,omitempty" yaml:"certificate,omitempty"`
+	SSH         *sshcert.Request     `json:"ssh,omitempty" yaml:"ssh,omitempty"`
 }
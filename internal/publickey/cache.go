@@ -18,6 +18,8 @@ package publickey
 
 import (
 	"time"
+
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
 )
 
 // Cache Interface
@@ -27,8 +29,9 @@ type Cache interface {
 	Shutdown()
 }
 
-// Config The config
+// Config The config. Clock is the source of time. Default is the system clock
 type Config struct {
 	CacheRefreshInterval            time.Duration
 	RequestTimeout, IdleConnections int
+	Clock                           clock.Clock
 }
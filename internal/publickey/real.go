@@ -29,6 +29,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
 	"go.uber.org/zap"
 )
 
@@ -45,7 +46,8 @@ type RealCache struct {
 	mutex      sync.RWMutex
 	internal   map[string]*PublicKey
 	closed     chan struct{}
-	ticker     *time.Ticker
+	ticker     clock.Ticker
+	clock      clock.Clock
 	wg         sync.WaitGroup
 }
 
@@ -70,10 +72,16 @@ func (config *Config) Build() (Cache, error) {
 		idleConnections = config.IdleConnections
 	}
 
+	timeSource := clock.Real()
+	if config.Clock != nil {
+		timeSource = config.Clock
+	}
+
 	t := &RealCache{
 		internal: make(map[string]*PublicKey),
 		closed:   make(chan struct{}),
-		ticker:   time.NewTicker(cacheRefreshInterval),
+		ticker:   timeSource.NewTicker(cacheRefreshInterval),
+		clock:    timeSource,
 		wg:       sync.WaitGroup{},
 		httpClient: &http.Client{
 			Transport: &http.Transport{
@@ -88,9 +96,10 @@ func (config *Config) Build() (Cache, error) {
 		for {
 			select {
 			case <-t.closed:
+				t.ticker.Stop()
 				t.wg.Done()
 				return
-			case <-t.ticker.C:
+			case <-t.ticker.C():
 				t.processCache()
 
 			}
@@ -111,7 +120,7 @@ func (t *RealCache) processCache() {
 
 	for key, e := range t.internal {
 
-		if time.Now().Unix() > e.Exp {
+		if t.clock.Now().Unix() > e.Exp {
 			removes = append(removes, key)
 			zap.L().Info(fmt.Sprintf("Ejecting->%s", e.JSON()))
 		} else {
@@ -173,6 +182,7 @@ func (t *RealCache) GetKey(iss, kid string) (*PublicKey, error) {
 						publicKey, err := newKey(&jwk)
 						if err == nil {
 							publicKey.Iss = iss
+							publicKey.Exp = t.clock.Now().Unix() + int64(defaultKeyLifetime)
 
 							t.internal[key] = publicKey
 
@@ -352,7 +362,6 @@ func newKeyEC(jwk *jwk) (*PublicKey, error) {
 			X:     new(big.Int).SetBytes(byteX),
 			Y:     new(big.Int).SetBytes(byteY),
 		},
-		Exp: time.Now().Unix() + int64(defaultKeyLifetime),
 		Kid: jwk.Kid,
 	}, nil
 
@@ -18,12 +18,17 @@ package secret
 
 import (
 	"crypto/sha256"
-	"encoding/base32"
 	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
+	"github.com/jodydadescott/tokens2secrets/internal/event"
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
+	"github.com/jodydadescott/tokens2secrets/internal/peer"
+	"github.com/jodydadescott/tokens2secrets/internal/seed"
+	"github.com/jodydadescott/tokens2secrets/internal/store"
 	"github.com/jodydadescott/tokens2secrets/internal/timeperiod"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
@@ -43,21 +48,41 @@ var ErrSecretNotFound error = errors.New("Secret with given name not found")
 // ErrAuthDenied ...
 var ErrAuthDenied error = errors.New("Authorization Denied")
 
-// Config Config
+// Config Config. If Events is set then an expired and a rotated event are
+// published each time a secret rolls over to a new period and an event is
+// published when a secret fails to generate. If
+// Store is set then secrets that are not derived from a seed are served from
+// the Store. MasterKey is required for secrets with the hkdf-sha256-v1
+// Derivation. Clock is the source of time. Default is the system clock
 type Config struct {
-	Secrets []*Secret
+	Secrets   []*Secret
+	Events    *event.Publisher
+	Store     *store.Store
+	MasterKey *masterkey.Key
+	Clock     clock.Clock
 }
 
 type secretWrapper struct {
-	name, seed string
+	name       string
+	seeds      *seed.Seeds
 	timePeriod *timeperiod.TimePeriod
 	mutex      sync.Mutex
+	lastEpoch  int64
+	format     *format
+	derivation string
+	masterKey  *masterkey.Key
 }
 
 // Cache Manages shared secrets
 type Cache struct {
-	mutex    sync.RWMutex
-	internal map[string]*secretWrapper
+	mutex     sync.RWMutex
+	internal  map[string]*secretWrapper
+	events    *event.Publisher
+	store     *store.Store
+	masterKey *masterkey.Key
+	clock     clock.Clock
+	closed    chan struct{}
+	wg        sync.WaitGroup
 }
 
 // Build Returns a new Cache
@@ -66,7 +91,16 @@ func (config *Config) Build() (*Cache, error) {
 	zap.L().Debug("Starting")
 
 	t := &Cache{
-		internal: make(map[string]*secretWrapper),
+		internal:  make(map[string]*secretWrapper),
+		events:    config.Events,
+		store:     config.Store,
+		masterKey: config.MasterKey,
+		clock:     clock.Real(),
+		closed:    make(chan struct{}),
+	}
+
+	if config.Clock != nil {
+		t.clock = config.Clock
 	}
 
 	err := t.loadSecrets(config.Secrets)
@@ -74,6 +108,13 @@ func (config *Config) Build() (*Cache, error) {
 		return nil, err
 	}
 
+	// Secrets are derived on request so the rollover is only watched for
+	// if there is someone to tell
+	if t.events != nil {
+		t.wg.Add(1)
+		go t.run()
+	}
+
 	return t, nil
 }
 
@@ -107,22 +148,57 @@ func (t *Cache) addSecret(secret *Secret) error {
 		return fmt.Errorf("Name is required")
 	}
 
-	if secret.Seed == "" {
+	err := masterkey.ValidateDerivation(secret.Derivation)
+	if err != nil {
+		return fmt.Errorf("Secret %s is invalid; %s", secret.Name, err.Error())
+	}
+
+	derivation := masterkey.DerivationSeed
+	if secret.Derivation != "" {
+		derivation = secret.Derivation
+	}
+
+	if derivation == masterkey.DerivationSeed && secret.Seed == "" && len(secret.Seeds) == 0 {
 		return fmt.Errorf("Seed is required")
 	}
 
+	if derivation != masterkey.DerivationSeed && t.masterKey == nil {
+		return fmt.Errorf("Secret %s derivation %s requires the master key", secret.Name, derivation)
+	}
+
 	lifetime := defaulLifetime
 
 	if secret.Lifetime > 0 {
 		lifetime = secret.Lifetime
 	}
 
-	seed := base32.StdEncoding.EncodeToString([]byte(secret.Seed))
+	timePeriod, err := (&timeperiod.Config{
+		Lifetime: lifetime,
+		Schedule: secret.Schedule,
+		Offset:   secret.Offset,
+		Stagger:  secret.Stagger,
+	}).Build(secret.Name)
+	if err != nil {
+		return fmt.Errorf("Secret %s is invalid; %s", secret.Name, err.Error())
+	}
+
+	seeds, err := seed.New(secret.Seed, secret.Seeds)
+	if err != nil {
+		return fmt.Errorf("Secret %s is invalid; %s", secret.Name, err.Error())
+	}
+
+	format, err := newFormat(secret)
+	if err != nil {
+		return fmt.Errorf("Secret %s is invalid; %s", secret.Name, err.Error())
+	}
 
 	t.internal[secret.Name] = &secretWrapper{
 		name:       secret.Name,
-		timePeriod: timeperiod.NewPeriod(lifetime),
-		seed:       seed,
+		timePeriod: timePeriod,
+		seeds:      seeds,
+		format:     format,
+		derivation: derivation,
+		masterKey:  t.masterKey,
 	}
 
 	return nil
@@ -145,6 +221,9 @@ func (t *Cache) GetSecret(name string) (*Secret, error) {
 	wrapper, ok = t.internal[name]
 
 	if !ok {
+		if t.store != nil {
+			return t.getStoredSecret(name)
+		}
 		zap.L().Debug(fmt.Sprintf("Secret with name %s not found", name))
 		return nil, ErrNotFound
 	}
@@ -152,7 +231,7 @@ func (t *Cache) GetSecret(name string) (*Secret, error) {
 	wrapper.mutex.Lock()
 	defer wrapper.mutex.Unlock()
 
-	now := getTime()
+	now := t.getTime()
 
 	var err error
 	var nowsecret string
@@ -163,6 +242,12 @@ func (t *Cache) GetSecret(name string) (*Secret, error) {
 
 	nowsecret, err = wrapper.getSecretString(nowPeriod.Time())
 	if err != nil {
+		t.events.Publish(&event.Event{
+			Type:  event.TypeFailed,
+			Kind:  event.KindSecret,
+			Name:  name,
+			Error: err.Error(),
+		})
 		return nil, err
 	}
 
@@ -188,13 +273,122 @@ func (t *Cache) GetSecret(name string) (*Secret, error) {
 	return result, nil
 }
 
+// getStoredSecret returns the secret from the store. Stored secrets do not
+// expire and change only when rotated so Exp and the next secret are not set.
+func (t *Cache) getStoredSecret(name string) (*Secret, error) {
+
+	value, err := t.store.Get(name)
+	if err == store.ErrNotFound {
+		zap.L().Debug(fmt.Sprintf("Secret with name %s not found", name))
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("Unable to get secret %s from store; err->%s", name, err.Error()))
+		return nil, err
+	}
+
+	return &Secret{
+		Secret:  value.Value,
+		Version: value.Version,
+	}, nil
+}
+
+func (t *Cache) run() {
+
+	defer t.wg.Done()
+
+	ticker := t.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	t.checkRollover(t.getTime())
+
+	for {
+		select {
+		case <-t.closed:
+			return
+		case <-ticker.C():
+			t.checkRollover(t.getTime())
+		}
+	}
+}
+
+// checkRollover publishes an expired event for the previous period and a
+// rotated event for the new period of each secret that has rolled over since
+// the last check
+func (t *Cache) checkRollover(now time.Time) {
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, wrapper := range t.internal {
+
+		wrapper.mutex.Lock()
+		nowPeriod := wrapper.timePeriod.From(now)
+		previous := wrapper.lastEpoch
+		wrapper.lastEpoch = nowPeriod.Epoch
+		wrapper.mutex.Unlock()
+
+		// The first check only records the period
+		if previous == 0 || previous == nowPeriod.Epoch {
+			continue
+		}
+
+		zap.L().Debug(fmt.Sprintf("Secret %s rolled over", wrapper.name))
+
+		t.events.Publish(&event.Event{
+			Type: event.TypeExpired,
+			Kind: event.KindSecret,
+			Name: wrapper.name,
+			Time: nowPeriod.Time().Unix(),
+			Exp:  nowPeriod.Time().Unix(),
+		})
+
+		t.events.Publish(&event.Event{
+			Type: event.TypeRotated,
+			Kind: event.KindSecret,
+			Name: wrapper.name,
+			Time: nowPeriod.Time().Unix(),
+			Exp:  nowPeriod.Next().Time().Unix(),
+		})
+	}
+}
+
+// GetFingerprints Returns the fingerprint of each secret for the period of
+// now so that it may be compared with other instances
+func (t *Cache) GetFingerprints(now time.Time, key []byte) []*peer.Fingerprint {
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	var result []*peer.Fingerprint
+	for _, wrapper := range t.internal {
+		wrapper.mutex.Lock()
+		period := wrapper.timePeriod.From(now)
+		secret, err := wrapper.getSecretString(period.Time())
+		wrapper.mutex.Unlock()
+		if err != nil {
+			zap.L().Error(fmt.Sprintf("Unable to get fingerprint for secret %s; err->%s", wrapper.name, err.Error()))
+			continue
+		}
+		result = append(result, peer.NewFingerprint(key, event.KindSecret, wrapper.name, period.Epoch, secret))
+	}
+	return result
+}
+
 func (t *secretWrapper) getSecretString(now time.Time) (string, error) {
 
+	if t.derivation == masterkey.DerivationHKDFSHA256v1 {
+		return t.getDerivedSecretString(now)
+	}
+
 	// The OTP will only be 8 random digits. We combine this with the original
 	// seed and get a hash. Then we convert the hex hash to a string based on
 	// our defined charset
 
-	otp, err := totp.GenerateCodeCustom(t.seed, now, totp.ValidateOpts{
+	current := t.seeds.For(now)
+
+	otp, err := totp.GenerateCodeCustom(current, now, totp.ValidateOpts{
 		Period:    30,
 		Skew:      1,
 		Digits:    otp.DigitsEight,
@@ -206,7 +400,46 @@ func (t *secretWrapper) getSecretString(now time.Time) (string, error) {
 		return "", ErrGenFail
 	}
 
-	hash := sha256.Sum256([]byte(otp + t.seed))
+	if t.format != nil {
+		secret, err := t.format.generate([]byte(otp + current))
+		if err != nil {
+			zap.L().Error(fmt.Sprintf("Unexpected error %s", err))
+			return "", ErrGenFail
+		}
+		return secret, nil
+	}
+
+	hash := sha256.Sum256([]byte(otp + current))
+
+	b := make([]byte, 28)
+	for i := range b {
+		b[i] = getChar(hash[i])
+	}
+
+	return string(b), nil
+}
+
+// getDerivedSecretString returns the secret derived from the master key, the
+// name and the index of the period of now
+func (t *secretWrapper) getDerivedSecretString(now time.Time) (string, error) {
+
+	period := t.timePeriod.From(now)
+	index := period.Index()
+
+	hash, err := t.masterKey.Derive(event.KindSecret, t.name, index, 32)
+	if err != nil {
+		zap.L().Error(fmt.Sprintf("Unexpected error %s", err))
+		return "", ErrGenFail
+	}
+
+	if t.format != nil {
+		secret, err := t.format.generate(hash)
+		if err != nil {
+			zap.L().Error(fmt.Sprintf("Unexpected error %s", err))
+			return "", ErrGenFail
+		}
+		return secret, nil
+	}
 
 	b := make([]byte, 28)
 	for i := range b {
@@ -241,12 +474,14 @@ func int31n(n int, input int64) int32 {
 	return int32(prod >> 32)
 }
 
-func getTime() time.Time {
+func (t *Cache) getTime() time.Time {
 	// If running multiple instance the time must be the same so we statically use UTC
-	return time.Now().In(time.UTC)
+	return t.clock.Now().In(time.UTC)
 }
 
 // Shutdown Server
 func (t *Cache) Shutdown() {
 	zap.L().Debug("Stopping")
+	close(t.closed)
+	t.wg.Wait()
 }
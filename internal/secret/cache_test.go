@@ -0,0 +1,249 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
+	"github.com/jodydadescott/tokens2secrets/internal/event"
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
+	"github.com/jodydadescott/tokens2secrets/internal/seed"
+	"github.com/jodydadescott/tokens2secrets/internal/store"
+)
+
+func TestStoredSecret(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	encoded, _ := masterkey.Generate()
+	key, _ := masterkey.Parse(encoded)
+
+	s, err := (&store.Config{Path: filepath.Join(dir, "store.json"), Key: key}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	s.Put("apikey", "abc123")
+
+	cache, err := (&Config{
+		Secrets: []*Secret{&Secret{Name: "derived", Seed: "seed"}},
+		Store:   s,
+	}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	result, err := cache.GetSecret("apikey")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if result.Secret != "abc123" || result.Version != 1 || result.Exp != 0 {
+		t.Fatalf("Unexpected secret %s", result.JSON())
+	}
+
+	// Derived secrets are not affected by the store
+	result, err = cache.GetSecret("derived")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if result.Version != 0 || result.Exp == 0 {
+		t.Fatalf("Unexpected secret %s", result.JSON())
+	}
+
+	if _, err := cache.GetSecret("other"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound")
+	}
+
+}
+
+func TestDerivedSecret(t *testing.T) {
+
+	encoded, _ := masterkey.Generate()
+	key, _ := masterkey.Parse(encoded)
+
+	secrets := []*Secret{
+		&Secret{Name: "secret1", Derivation: masterkey.DerivationHKDFSHA256v1, Format: FormatHex, Length: 16},
+	}
+
+	if _, err := (&Config{Secrets: secrets}).Build(); err == nil {
+		t.Fatalf("Expected error for derivation without master key")
+	}
+
+	cache, err := (&Config{Secrets: secrets, MasterKey: key}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	other, _ := (&Config{Secrets: secrets, MasterKey: key}).Build()
+	defer other.Shutdown()
+
+	a, err := cache.GetSecret("secret1")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	b, _ := other.GetSecret("secret1")
+
+	if len(a.Secret) != 16 || a.Secret != b.Secret {
+		t.Fatalf("Expected the same secret from the same master key")
+	}
+
+}
+
+func TestSeedRollover(t *testing.T) {
+
+	activation := time.Date(2020, 3, 12, 15, 0, 0, 0, time.UTC)
+
+	cache, err := (&Config{
+		Secrets: []*Secret{
+			&Secret{
+				Name:     "secret1",
+				Seed:     "old",
+				Lifetime: time.Hour,
+				Seeds:    []*SeedVersion{&SeedVersion{Seed: "new", Activation: activation}},
+			},
+		},
+	}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	wrapper := cache.internal["secret1"]
+	oldSeeds, _ := seed.New("old", nil)
+	newSeeds, _ := seed.New("new", nil)
+	old := &secretWrapper{seeds: oldSeeds}
+	newer := &secretWrapper{seeds: newSeeds}
+
+	before := activation.Add(-time.Hour)
+
+	current, _ := wrapper.getSecretString(before)
+	expected, _ := old.getSecretString(before)
+	if current != expected {
+		t.Fatalf("Expected old seed before activation")
+	}
+
+	next, _ := wrapper.getSecretString(activation)
+	expected, _ = newer.getSecretString(activation)
+	if next != expected {
+		t.Fatalf("Expected new seed from activation")
+	}
+
+}
+
+func TestStagger(t *testing.T) {
+
+	cache, err := (&Config{
+		Secrets: []*Secret{
+			&Secret{Name: "secret1", Seed: "seed", Lifetime: time.Hour, Stagger: true},
+			&Secret{Name: "secret2", Seed: "seed", Lifetime: time.Hour, Stagger: true},
+			&Secret{Name: "secret3", Seed: "seed", Lifetime: time.Hour, Offset: 15 * time.Minute, Stagger: true},
+		},
+	}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	if cache.internal["secret1"].timePeriod.Offset == cache.internal["secret2"].timePeriod.Offset {
+		t.Fatalf("Expected different offsets")
+	}
+
+	// An explicit offset is used in place of the hash
+	now := time.Date(2020, 3, 12, 15, 20, 0, 0, time.UTC)
+	if !cache.internal["secret3"].timePeriod.From(now).Time().Equal(time.Date(2020, 3, 12, 15, 15, 0, 0, time.UTC)) {
+		t.Fatalf("Expected period to start at offset")
+	}
+
+}
+
+// channelSink delivers each event on a channel
+type channelSink struct {
+	c chan *event.Event
+}
+
+func (t *channelSink) Send(e *event.Event) error {
+	t.c <- e
+	return nil
+}
+
+func TestEvents(t *testing.T) {
+
+	sink := &channelSink{c: make(chan *event.Event, 10)}
+
+	events, err := (&event.Config{Sinks: []event.Sink{sink}}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer events.Shutdown()
+
+	fake := clock.NewFake(time.Date(2020, 3, 12, 14, 59, 0, 0, time.UTC))
+
+	cache, err := (&Config{
+		Secrets: []*Secret{
+			&Secret{Name: "secret1", Seed: "seed", Lifetime: time.Hour},
+		},
+		Events: events,
+		Clock:  fake,
+	}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	// Each rollover publishes the expiry of the previous period and the
+	// rotation to the new period
+	boundary := time.Date(2020, 3, 12, 15, 0, 0, 0, time.UTC)
+	expect := []struct {
+		eventType string
+		exp       time.Time
+	}{
+		{event.TypeExpired, boundary},
+		{event.TypeRotated, boundary.Add(time.Hour)},
+	}
+
+	for _, e := range expect {
+		var received *event.Event
+		for received == nil {
+			fake.Advance(time.Second)
+			select {
+			case received = <-sink.c:
+			case <-time.After(10 * time.Millisecond):
+			}
+			if fake.Now().After(boundary.Add(time.Minute)) {
+				t.Fatalf("Expected %s event", e.eventType)
+			}
+		}
+		if received.Type != e.eventType || received.Kind != event.KindSecret || received.Name != "secret1" || received.Exp != e.exp.Unix() {
+			t.Fatalf("Unexpected event %s", received.JSON())
+		}
+	}
+
+}
@@ -0,0 +1,296 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// FormatCharset Characters from Charset. Default length is 28
+	FormatCharset = "charset"
+
+	// FormatHex Lower case hex. Default length is 64
+	FormatHex = "hex"
+
+	// FormatBase64 Base64 without padding. Default length is 43
+	FormatBase64 = "base64"
+
+	// FormatBase64URL URL safe base64 without padding. Default length is 43
+	FormatBase64URL = "base64url"
+
+	// FormatPIN Digits. Default length is 6
+	FormatPIN = "pin"
+
+	// FormatPassphrase Words from the EFF short word list joined by Separator.
+	// Length is the number of words. Default is 6
+	FormatPassphrase = "passphrase"
+)
+
+const (
+	// ClassLower Lower case letters
+	ClassLower = "lower"
+
+	// ClassUpper Upper case letters
+	ClassUpper = "upper"
+
+	// ClassDigit Digits
+	ClassDigit = "digit"
+
+	// ClassSymbol Anything that is not a letter or digit
+	ClassSymbol = "symbol"
+)
+
+const (
+	defaultSeparator = "-"
+	maxLength        = 1024
+	maxAttempts      = 1000
+)
+
+var defaultLengths = map[string]int{
+	FormatCharset:    28,
+	FormatHex:        64,
+	FormatBase64:     43,
+	FormatBase64URL:  43,
+	FormatPIN:        6,
+	FormatPassphrase: 6,
+}
+
+// format defines how the secret string is generated from the OTP and seed.
+// The generated secret is deterministic so that every instance of the server
+// generates the same secret.
+type format struct {
+	name, charset, separator string
+	length                   int
+	require                  []string
+}
+
+// newFormat returns the format for the secret or nil if the secret does not
+// specify a format in which case the original 28 character format is used
+func newFormat(secret *Secret) (*format, error) {
+
+	if secret.Format == "" && secret.Length == 0 && secret.Charset == "" && len(secret.Require) == 0 && secret.Separator == "" {
+		return nil, nil
+	}
+
+	name := strings.ToLower(secret.Format)
+	if name == "" {
+		name = FormatCharset
+	}
+
+	length, exist := defaultLengths[name]
+	if !exist {
+		return nil, fmt.Errorf("Format %s is not supported", secret.Format)
+	}
+
+	if secret.Length < 0 || secret.Length > maxLength {
+		return nil, fmt.Errorf("Length must be between 1 and %d", maxLength)
+	}
+
+	if secret.Length > 0 {
+		length = secret.Length
+	}
+
+	t := &format{
+		name:      name,
+		length:    length,
+		separator: secret.Separator,
+	}
+
+	if secret.Charset != "" && name != FormatCharset {
+		return nil, fmt.Errorf("Charset is only valid with format %s", FormatCharset)
+	}
+
+	if secret.Separator != "" && name != FormatPassphrase {
+		return nil, fmt.Errorf("Separator is only valid with format %s", FormatPassphrase)
+	}
+
+	if name == FormatPassphrase && t.separator == "" {
+		t.separator = defaultSeparator
+	}
+
+	if name == FormatCharset {
+		t.charset = secretCharset
+		if secret.Charset != "" {
+			t.charset = secret.Charset
+		}
+		if len(t.charset) < 2 || len(t.charset) > 256 {
+			return nil, fmt.Errorf("Charset must have between 2 and 256 characters")
+		}
+		for i := 0; i < len(t.charset); i++ {
+			if t.charset[i] > 127 || strings.IndexByte(t.charset[i+1:], t.charset[i]) >= 0 {
+				return nil, fmt.Errorf("Charset must be unique ASCII characters")
+			}
+		}
+	}
+
+	if len(secret.Require) > 0 {
+		if name != FormatCharset {
+			return nil, fmt.Errorf("Require is only valid with format %s", FormatCharset)
+		}
+		if len(secret.Require) > length {
+			return nil, fmt.Errorf("Length %d is less then the number of required classes", length)
+		}
+		for _, class := range secret.Require {
+			class = strings.ToLower(class)
+			if !strings.ContainsAny(t.charset, classChars(class)) && !(class == ClassSymbol && hasSymbol(t.charset)) {
+				return nil, fmt.Errorf("Required class %s is not supported or not in charset", class)
+			}
+			t.require = append(t.require, class)
+		}
+	}
+
+	return t, nil
+}
+
+// generate returns the secret string derived from the input
+func (t *format) generate(input []byte) (string, error) {
+
+	s := &stream{input: input}
+
+	switch t.name {
+
+	case FormatHex:
+		b := s.bytes((t.length + 1) / 2)
+		return hex.EncodeToString(b)[:t.length], nil
+
+	case FormatBase64:
+		b := s.bytes((t.length*3)/4 + 3)
+		return base64.RawStdEncoding.EncodeToString(b)[:t.length], nil
+
+	case FormatBase64URL:
+		b := s.bytes((t.length*3)/4 + 3)
+		return base64.RawURLEncoding.EncodeToString(b)[:t.length], nil
+
+	case FormatPIN:
+		return s.chars("0123456789", t.length), nil
+
+	case FormatPassphrase:
+		words := make([]string, t.length)
+		for i := range words {
+			words[i] = wordList[s.uniform(len(wordList))]
+		}
+		return strings.Join(words, t.separator), nil
+
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		result := s.chars(t.charset, t.length)
+		if t.satisfies(result) {
+			return result, nil
+		}
+	}
+
+	return "", fmt.Errorf("Unable to generate secret with required classes")
+}
+
+func (t *format) satisfies(secret string) bool {
+	for _, class := range t.require {
+		if class == ClassSymbol {
+			if !hasSymbol(secret) {
+				return false
+			}
+			continue
+		}
+		if !strings.ContainsAny(secret, classChars(class)) {
+			return false
+		}
+	}
+	return true
+}
+
+func classChars(class string) string {
+	switch class {
+	case ClassLower:
+		return "abcdefghijklmnopqrstuvwxyz"
+	case ClassUpper:
+		return "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	case ClassDigit:
+		return "0123456789"
+	}
+	return ""
+}
+
+func hasSymbol(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune(classChars(ClassLower)+classChars(ClassUpper)+classChars(ClassDigit), c) {
+			return true
+		}
+	}
+	return false
+}
+
+// stream is a deterministic stream of bytes derived from the input. Each
+// block is the SHA256 of the input and a counter.
+type stream struct {
+	input   []byte
+	buf     []byte
+	counter uint32
+}
+
+func (t *stream) next() byte {
+	if len(t.buf) == 0 {
+		counter := make([]byte, 4)
+		binary.BigEndian.PutUint32(counter, t.counter)
+		block := sha256.Sum256(append(append([]byte{}, t.input...), counter...))
+		t.buf = block[:]
+		t.counter++
+	}
+	b := t.buf[0]
+	t.buf = t.buf[1:]
+	return b
+}
+
+func (t *stream) bytes(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = t.next()
+	}
+	return b
+}
+
+// uniform returns a value in [0,n) without modulo bias by rejecting values
+// beyond the largest multiple of n
+func (t *stream) uniform(n int) int {
+	if n <= 256 {
+		limit := 256 - 256%n
+		for {
+			if v := int(t.next()); v < limit {
+				return v % n
+			}
+		}
+	}
+	limit := 65536 - 65536%n
+	for {
+		if v := int(t.next())<<8 | int(t.next()); v < limit {
+			return v % n
+		}
+	}
+}
+
+func (t *stream) chars(charset string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = charset[t.uniform(len(charset))]
+	}
+	return string(b)
+}
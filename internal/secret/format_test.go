@@ -0,0 +1,129 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+
+	input := []byte("12345678seed")
+
+	vectors := []struct {
+		secret *Secret
+		regex  string
+	}{
+		{&Secret{Format: FormatHex}, "^[0-9a-f]{64}$"},
+		{&Secret{Format: FormatHex, Length: 7}, "^[0-9a-f]{7}$"},
+		{&Secret{Format: FormatBase64, Length: 40}, "^[A-Za-z0-9+/]{40}$"},
+		{&Secret{Format: FormatBase64URL}, "^[A-Za-z0-9_-]{43}$"},
+		{&Secret{Format: FormatPIN}, "^[0-9]{6}$"},
+		{&Secret{Format: FormatPassphrase, Length: 4, Separator: " "}, "^[a-z]+( [a-z]+){3}$"},
+		{&Secret{Length: 12, Charset: "abc"}, "^[abc]{12}$"},
+		{&Secret{Format: FormatCharset, Length: 4, Require: []string{"lower", "upper", "digit", "symbol"}}, "^[a-zA-Z0-9@!]{4}$"},
+	}
+
+	for _, v := range vectors {
+
+		format, err := newFormat(v.secret)
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+
+		result, err := format.generate(input)
+		if err != nil {
+			t.Fatalf("Unexpected err %s", err)
+		}
+
+		if !regexp.MustCompile(v.regex).MatchString(result) {
+			t.Fatalf("Secret %s does not match %s", result, v.regex)
+		}
+
+		again, _ := format.generate(input)
+		if again != result {
+			t.Fatalf("Expected secret to be deterministic")
+		}
+
+		if len(v.secret.Require) > 0 && !format.satisfies(result) {
+			t.Fatalf("Secret %s does not have the required classes", result)
+		}
+	}
+
+	if format, _ := newFormat(&Secret{}); format != nil {
+		t.Fatalf("Expected no format for secret without format")
+	}
+
+	invalid := []*Secret{
+		&Secret{Format: "unknown"},
+		&Secret{Format: FormatHex, Charset: "abc"},
+		&Secret{Charset: "aa"},
+		&Secret{Charset: "abc", Require: []string{"digit"}},
+		&Secret{Length: 1, Require: []string{"lower", "upper"}},
+		&Secret{Format: FormatPIN, Length: maxLength + 1},
+	}
+
+	for _, secret := range invalid {
+		if _, err := newFormat(secret); err == nil {
+			t.Fatalf("Expected error for %+v", secret)
+		}
+	}
+
+}
+
+func TestSecretFormat(t *testing.T) {
+
+	config := &Config{
+		Secrets: []*Secret{
+			&Secret{Name: "legacy", Seed: "E17cUHMYtU+FvpK3kig7o5", Lifetime: time.Hour},
+			&Secret{Name: "pin", Seed: "E17cUHMYtU+FvpK3kig7o5", Lifetime: time.Hour, Format: FormatPIN, Length: 8},
+		},
+	}
+
+	cache, err := config.Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer cache.Shutdown()
+
+	legacy, err := cache.GetSecret("legacy")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if len(legacy.Secret) != 28 || strings.Trim(legacy.Secret, secretCharset) != "" {
+		t.Fatalf("Unexpected legacy secret %s", legacy.Secret)
+	}
+
+	pin, err := cache.GetSecret("pin")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if !regexp.MustCompile("^[0-9]{8}$").MatchString(pin.Secret) {
+		t.Fatalf("Unexpected pin %s", pin.Secret)
+	}
+
+	config.Secrets[1].Format = "unknown"
+	if _, err := config.Build(); err == nil {
+		t.Fatalf("Expected error for unknown format")
+	}
+
+}
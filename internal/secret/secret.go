@@ -21,19 +21,45 @@ import (
 	"time"
 
 	"github.com/jinzhu/copier"
+	"github.com/jodydadescott/tokens2secrets/internal/seed"
 )
 
 // Secret Holds a secret. Both state and config.
+//
+// Format: charset, hex, base64, base64url, pin or passphrase with Length,
+// Charset, Require and Separator. See newFormat
+//
+// Version: Only set for stored secrets and incremented each rotation
+//
+// Derivation: seed (default) or hkdf-sha256-v1
+//
+// Seeds: See seed.Version
+//
+// Schedule, Offset and Stagger: See timeperiod.Config
 type Secret struct {
-	Name       string        `json:"name,omitempty" yaml:"name,omitempty"`
-	Seed       string        `json:"seed,omitempty" yaml:"seed,omitempty"`
-	Lifetime   time.Duration `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
-	Exp        int64         `json:"exp,omitempty" yaml:"exp,omitempty"`
-	Secret     string        `json:"secret,omitempty" yaml:"secret,omitempty"`
-	NextExp    int64         `json:"nextExp,omitempty" yaml:"nextExp,omitempty"`
-	NextSecret string        `json:"nextSecret,omitempty" yaml:"nextSecret,omitempty"`
+	Name       string         `json:"name,omitempty" yaml:"name,omitempty"`
+	Seed       string         `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Lifetime   time.Duration  `json:"lifetime,omitempty" yaml:"lifetime,omitempty"`
+	Exp        int64          `json:"exp,omitempty" yaml:"exp,omitempty"`
+	Secret     string         `json:"secret,omitempty" yaml:"secret,omitempty"`
+	NextExp    int64          `json:"nextExp,omitempty" yaml:"nextExp,omitempty"`
+	NextSecret string         `json:"nextSecret,omitempty" yaml:"nextSecret,omitempty"`
+	Format     string         `json:"format,omitempty" yaml:"format,omitempty"`
+	Length     int            `json:"length,omitempty" yaml:"length,omitempty"`
+	Charset    string         `json:"charset,omitempty" yaml:"charset,omitempty"`
+	Require    []string       `json:"require,omitempty" yaml:"require,omitempty"`
+	Separator  string         `json:"separator,omitempty" yaml:"separator,omitempty"`
+	Version    int            `json:"version,omitempty" yaml:"version,omitempty"`
+	Derivation string         `json:"derivation,omitempty" yaml:"derivation,omitempty"`
+	Seeds      []*SeedVersion `json:"seeds,omitempty" yaml:"seeds,omitempty"`
+	Schedule   string         `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	Offset     time.Duration  `json:"offset,omitempty" yaml:"offset,omitempty"`
+	Stagger    bool           `json:"stagger,omitempty" yaml:"stagger,omitempty"`
 }
 
+// SeedVersion A seed and the time it becomes active. See seed.Version
+type SeedVersion = seed.Version
+
 // JSON Return JSON String representation
 func (t *Secret) JSON() string {
 	j, _ := json.Marshal(t)
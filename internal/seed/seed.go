@@ -0,0 +1,105 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package seed
+
+import (
+	"encoding/base32"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Version A seed and the time it becomes active. The seed is used from the
+// first period that starts at or after the Activation. As the next keytab or
+// secret is provided in the second half of the prior period clients receive
+// the one from the new seed before the switch.
+type Version struct {
+	Seed       string    `json:"seed,omitempty" yaml:"seed,omitempty"`
+	Activation time.Time `json:"activation,omitempty" yaml:"activation,omitempty"`
+}
+
+type version struct {
+	seed       string
+	activation time.Time
+}
+
+// Seeds The base seed and the seed versions of a keytab or secret. The seeds
+// are base32 encoded as required by TOTP.
+type Seeds struct {
+	base     string
+	versions []*version
+}
+
+// New Returns the Seeds sorted by activation. If there is no base seed then
+// the first seed version is also used before it is activated.
+func New(base string, versions []*Version) (*Seeds, error) {
+
+	t := &Seeds{}
+	seen := make(map[int64]bool)
+
+	for _, v := range versions {
+
+		if v == nil || v.Seed == "" {
+			return nil, fmt.Errorf("Seed version is missing seed")
+		}
+
+		if v.Activation.IsZero() {
+			return nil, fmt.Errorf("Seed version is missing activation")
+		}
+
+		if seen[v.Activation.Unix()] {
+			return nil, fmt.Errorf("Seed versions have the same activation %s", v.Activation)
+		}
+		seen[v.Activation.Unix()] = true
+
+		t.versions = append(t.versions, &version{
+			seed:       base32.StdEncoding.EncodeToString([]byte(v.Seed)),
+			activation: v.Activation,
+		})
+	}
+
+	sort.Slice(t.versions, func(i, j int) bool {
+		return t.versions[i].activation.Before(t.versions[j].activation)
+	})
+
+	if base != "" {
+		t.base = base32.StdEncoding.EncodeToString([]byte(base))
+	} else if len(t.versions) > 0 {
+		t.base = t.versions[0].seed
+	}
+
+	return t, nil
+}
+
+// For Returns the seed for the period that starts at start. This is the seed
+// version with the latest activation at or before start or the base seed if
+// none are active.
+func (t *Seeds) For(start time.Time) string {
+
+	if t == nil {
+		return ""
+	}
+
+	seed := t.base
+	for _, v := range t.versions {
+		if v.activation.After(start) {
+			break
+		}
+		seed = v.seed
+	}
+	return seed
+}
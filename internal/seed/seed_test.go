@@ -0,0 +1,76 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package seed
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestSeeds(t *testing.T) {
+
+	first := time.Date(2020, 3, 12, 15, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+
+	seeds, err := New("old", []*Version{
+		&Version{Seed: "newest", Activation: second},
+		&Version{Seed: "new", Activation: first},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	encode := func(s string) string {
+		return base32.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	vectors := []struct {
+		start  time.Time
+		expect string
+	}{
+		{first.Add(-time.Hour), "old"},
+		{first, "new"},
+		{second.Add(-time.Hour), "new"},
+		{second, "newest"},
+	}
+
+	for _, v := range vectors {
+		if seeds.For(v.start) != encode(v.expect) {
+			t.Fatalf("Expected seed %s at %s", v.expect, v.start)
+		}
+	}
+
+	// Without a base seed the first version is used before it is activated
+	seeds, err = New("", []*Version{&Version{Seed: "new", Activation: first}})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if seeds.For(first.Add(-time.Hour)) != encode("new") {
+		t.Fatalf("Expected first version before activation")
+	}
+
+	if _, err := New("old", []*Version{&Version{Seed: "new"}}); err == nil {
+		t.Fatalf("Expected error for missing activation")
+	}
+
+	if _, err := New("old", []*Version{&Version{Seed: "a", Activation: first}, &Version{Seed: "b", Activation: first}}); err == nil {
+		t.Fatalf("Expected error for same activation")
+	}
+
+}
@@ -28,8 +28,15 @@ import (
 	"time"
 
 	"github.com/jodydadescott/tokens2secrets/config"
+	"github.com/jodydadescott/tokens2secrets/internal/certificate"
+	"github.com/jodydadescott/tokens2secrets/internal/event"
 	"github.com/jodydadescott/tokens2secrets/internal/keytab"
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
+	"github.com/jodydadescott/tokens2secrets/internal/nonce"
+	"github.com/jodydadescott/tokens2secrets/internal/peer"
 	"github.com/jodydadescott/tokens2secrets/internal/secret"
+	"github.com/jodydadescott/tokens2secrets/internal/sshcert"
+	"github.com/jodydadescott/tokens2secrets/internal/store"
 	"github.com/open-policy-agent/opa/rego"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -72,28 +79,182 @@ func (t *Loader) ServerConfig() (*Config, error) {
 	if t.Config.Policy != nil {
 		serverConfig.Policy = t.Config.Policy.Policy
 		serverConfig.NonceLifetime = t.Config.Policy.NonceLifetime
+		serverConfig.NonceSingleUse = t.Config.Policy.NonceSingleUse
+		serverConfig.NonceBinding = t.Config.Policy.NonceBinding
 		serverConfig.KeytabLifetime = t.Config.Policy.KeytabLifetime
+
+		switch t.Config.Policy.NonceMode {
+		case "", nonce.ModeLocal:
+		case nonce.ModeSigned:
+			// A signed nonce is accepted by any instance but only the instance
+			// that consumed it knows that it was used
+			if t.Config.Policy.NonceSingleUse {
+				return nil, fmt.Errorf("Nonce mode %s does not support nonceSingleUse", nonce.ModeSigned)
+			}
+			key, err := t.MasterKey()
+			if err != nil {
+				return nil, fmt.Errorf("Nonce mode %s; %s", nonce.ModeSigned, err.Error())
+			}
+			serverConfig.NonceKey, err = key.Derive("nonce", "signing", 0, 32)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("Nonce mode %s is not supported", t.Config.Policy.NonceMode)
+		}
+	}
+
+	if t.Config.Kadmin != nil {
+		serverConfig.KeytabKadmin = &keytab.Kadmin{
+			Exe:       t.Config.Kadmin.Exe,
+			Principal: t.Config.Kadmin.Principal,
+			Keytab:    t.Config.Kadmin.Keytab,
+		}
+	}
+
+	if t.Config.Rotation != nil {
+		serverConfig.KeytabWorkers = t.Config.Rotation.Workers
+		serverConfig.KeytabJitter = t.Config.Rotation.Jitter
+	}
+
+	if t.Config.Events != nil {
+		serverConfig.Events = &event.Config{}
+		for _, s := range t.Config.Events.Exec {
+			serverConfig.Events.Exec = append(serverConfig.Events.Exec, &event.Exec{
+				Command: s.Command,
+				Args:    s.Args,
+				Timeout: s.Timeout,
+			})
+		}
+		for _, s := range t.Config.Events.Webhooks {
+			serverConfig.Events.Webhooks = append(serverConfig.Events.Webhooks, &event.Webhook{
+				URL:     s.URL,
+				Headers: s.Headers,
+				Timeout: s.Timeout,
+			})
+		}
+		for _, s := range t.Config.Events.Files {
+			serverConfig.Events.Files = append(serverConfig.Events.Files, &event.File{
+				Path: s.Path,
+			})
+		}
+	}
+
+	if t.Config.Peers != nil {
+		serverConfig.Peers = &peer.Config{
+			Peers:     t.Config.Peers.Peers,
+			Token:     t.Config.Peers.Token,
+			Interval:  t.Config.Peers.Interval,
+			AllowHTTP: t.Config.Peers.AllowHTTP,
+		}
+	}
+
+	if t.Config.Certificate != nil {
+		serverConfig.Certificate = &certificate.Config{
+			CACert:      t.Config.Certificate.CACert,
+			CAKey:       t.Config.Certificate.CAKey,
+			Lifetime:    t.Config.Certificate.Lifetime,
+			MaxLifetime: t.Config.Certificate.MaxLifetime,
+		}
 	}
 
+	if t.Config.SSH != nil {
+		serverConfig.SSH = &sshcert.Config{
+			CAKey:       t.Config.SSH.CAKey,
+			Lifetime:    t.Config.SSH.Lifetime,
+			MaxLifetime: t.Config.SSH.MaxLifetime,
+		}
+	}
+
+	storeConfig, err := t.StoreConfig()
+	if err != nil {
+		return nil, err
+	}
+	serverConfig.Store = storeConfig
+
 	if t.Config.Data != nil {
 
+		// Sealed seeds are only opened here so that the plaintext is never
+		// in the Config
+		seeds := &seedOpener{loader: t}
+
 		if t.Config.Data.Keytabs != nil {
 			for _, s := range t.Config.Data.Keytabs {
+				seed, err := seeds.open(s.Seed, keytabSeedContext(s.Principal))
+				if err != nil {
+					return nil, fmt.Errorf("Keytab %s seed; %s", s.Principal, err.Error())
+				}
+				var versions []*keytab.SeedVersion
+				for _, v := range s.Seeds {
+					versionSeed, err := seeds.open(v.Seed, keytabSeedContext(s.Principal))
+					if err != nil {
+						return nil, fmt.Errorf("Keytab %s seed; %s", s.Principal, err.Error())
+					}
+					versions = append(versions, &keytab.SeedVersion{Seed: versionSeed, Activation: v.Activation})
+				}
 				serverConfig.KeytabKeytabs = append(serverConfig.KeytabKeytabs, &keytab.Keytab{
-					Principal: s.Principal,
-					Seed:      s.Seed,
-					Lifetime:  s.Lifetime,
+					Principal:     s.Principal,
+					Seed:          seed,
+					Lifetime:      s.Lifetime,
+					Backend:       s.Backend,
+					Enctypes:      s.Enctypes,
+					Kvno:          s.Kvno,
+					RotateKvno:    s.RotateKvno,
+					PrincipalType: s.PrincipalType,
+					Service:       s.Service,
+					Host:          s.Host,
+					SPNs:          s.SPNs,
+					Idle:          s.Idle,
+					Derivation:    s.Derivation,
+					Seeds:         versions,
+					Schedule:      s.Schedule,
+					Offset:        s.Offset,
+					Stagger:       s.Stagger,
 				})
+				if s.Derivation != "" && s.Derivation != masterkey.DerivationSeed {
+					serverConfig.MasterKey, err = seeds.masterKey()
+					if err != nil {
+						return nil, fmt.Errorf("Keytab %s derivation; %s", s.Principal, err.Error())
+					}
+				}
 			}
 		}
 
 		if t.Config.Data.Secrets != nil {
 			for _, s := range t.Config.Data.Secrets {
+				seed, err := seeds.open(s.Seed, secretSeedContext(s.Name))
+				if err != nil {
+					return nil, fmt.Errorf("Secret %s seed; %s", s.Name, err.Error())
+				}
+				var versions []*secret.SeedVersion
+				for _, v := range s.Seeds {
+					versionSeed, err := seeds.open(v.Seed, secretSeedContext(s.Name))
+					if err != nil {
+						return nil, fmt.Errorf("Secret %s seed; %s", s.Name, err.Error())
+					}
+					versions = append(versions, &secret.SeedVersion{Seed: versionSeed, Activation: v.Activation})
+				}
 				serverConfig.SecretSecrets = append(serverConfig.SecretSecrets, &secret.Secret{
-					Name:     s.Name,
-					Seed:     s.Seed,
-					Lifetime: s.Lifetime,
+					Name:       s.Name,
+					Seed:       seed,
+					Lifetime:   s.Lifetime,
+					Format:     s.Format,
+					Length:     s.Length,
+					Charset:    s.Charset,
+					Require:    s.Require,
+					Separator:  s.Separator,
+					Derivation: s.Derivation,
+					Seeds:      versions,
+					Schedule:   s.Schedule,
+					Offset:     s.Offset,
+					Stagger:    s.Stagger,
 				})
+				if s.Derivation != "" && s.Derivation != masterkey.DerivationSeed {
+					serverConfig.MasterKey, err = seeds.masterKey()
+					if err != nil {
+						return nil, fmt.Errorf("Secret %s derivation; %s", s.Name, err.Error())
+					}
+				}
 			}
 		}
 	}
@@ -101,6 +262,132 @@ func (t *Loader) ServerConfig() (*Config, error) {
 	return serverConfig, nil
 }
 
+// MasterKey Returns the master key from the key file or environment
+func (t *Loader) MasterKey() (*masterkey.Key, error) {
+	keyFile := ""
+	if t.Config.MasterKey != nil {
+		keyFile = t.Config.MasterKey.KeyFile
+	}
+	return masterkey.Load(keyFile)
+}
+
+// StoreConfig Returns the Store Config or nil if no store is configured
+func (t *Loader) StoreConfig() (*store.Config, error) {
+
+	if t.Config.Store == nil || t.Config.Store.Path == "" {
+		return nil, nil
+	}
+
+	key, err := t.MasterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.Config{
+		Path: t.Config.Store.Path,
+		Key:  key,
+	}, nil
+}
+
+// Seal Encrypts each plaintext keytab and secret seed in the Config with the
+// master key and returns the number of seeds sealed. Seeds are bound to the
+// principal or name so a sealed seed may not be copied to another entry.
+func (t *Loader) Seal() (int, error) {
+
+	if t.Config.Data == nil {
+		return 0, nil
+	}
+
+	key, err := t.MasterKey()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	seal := func(seed *string, context string) error {
+		if *seed == "" || masterkey.IsSealed(*seed) {
+			return nil
+		}
+		sealed, err := key.Seal(*seed, context)
+		if err != nil {
+			return err
+		}
+		*seed = sealed
+		count++
+		return nil
+	}
+
+	for _, s := range t.Config.Data.Keytabs {
+		err = seal(&s.Seed, keytabSeedContext(s.Principal))
+		if err != nil {
+			return 0, err
+		}
+		for _, v := range s.Seeds {
+			err = seal(&v.Seed, keytabSeedContext(s.Principal))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for _, s := range t.Config.Data.Secrets {
+		err = seal(&s.Seed, secretSeedContext(s.Name))
+		if err != nil {
+			return 0, err
+		}
+		for _, v := range s.Seeds {
+			err = seal(&v.Seed, secretSeedContext(s.Name))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// seedOpener opens sealed seeds. The master key is only loaded if a sealed
+// seed or a derivation that requires it is found so that other configs do
+// not require it.
+type seedOpener struct {
+	loader *Loader
+	key    *masterkey.Key
+}
+
+func (t *seedOpener) open(seed, context string) (string, error) {
+
+	if !masterkey.IsSealed(seed) {
+		return seed, nil
+	}
+
+	key, err := t.masterKey()
+	if err != nil {
+		return "", err
+	}
+
+	return key.Open(seed, context)
+}
+
+func (t *seedOpener) masterKey() (*masterkey.Key, error) {
+	if t.key == nil {
+		key, err := t.loader.MasterKey()
+		if err != nil {
+			return nil, err
+		}
+		t.key = key
+	}
+	return t.key, nil
+}
+
+func keytabSeedContext(principal string) string {
+	return "keytab/" + principal
+}
+
+func secretSeedContext(name string) string {
+	return "secret/" + name
+}
+
 // ZapConfig Returns Zap Config
 func (t *Loader) ZapConfig() (*zap.Config, error) {
 
@@ -0,0 +1,119 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jodydadescott/tokens2secrets/config"
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
+	"github.com/jodydadescott/tokens2secrets/internal/nonce"
+)
+
+func TestSeal(t *testing.T) {
+
+	key, _ := masterkey.Generate()
+	os.Setenv(masterkey.EnvKey, key)
+	defer os.Unsetenv(masterkey.EnvKey)
+
+	loader := NewLoader()
+	loader.Config.Data = &config.Data{
+		Keytabs: []*config.Keytab{&config.Keytab{Principal: "bob@EXAMPLE.COM", Seed: "keytabseed"}},
+		Secrets: []*config.Secret{&config.Secret{Name: "secret1", Seed: "secretseed"}},
+	}
+
+	count, err := loader.Seal()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if count != 2 {
+		t.Fatalf("Expected 2 seeds sealed, got %d", count)
+	}
+
+	if strings.Contains(loader.Config.JSON(), "keytabseed") || strings.Contains(loader.Config.JSON(), "secretseed") {
+		t.Fatalf("Config must not contain plaintext seeds")
+	}
+
+	// Sealing again does not seal twice
+	count, _ = loader.Seal()
+	if count != 0 {
+		t.Fatalf("Expected 0 seeds sealed, got %d", count)
+	}
+
+	loader.Config.Network = &config.Network{HTTPPort: 8080}
+
+	serverConfig, err := loader.ServerConfig()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if serverConfig.KeytabKeytabs[0].Seed != "keytabseed" || serverConfig.SecretSecrets[0].Seed != "secretseed" {
+		t.Fatalf("Expected seeds to be opened")
+	}
+
+	// A sealed seed is bound to its entry
+	loader.Config.Data.Secrets[0].Name = "secret2"
+	if _, err := loader.ServerConfig(); err == nil {
+		t.Fatalf("Expected error for seed moved to another secret")
+	}
+
+}
+
+func TestNonceMode(t *testing.T) {
+
+	loader := NewLoader()
+	loader.Config.Policy = &config.Policy{NonceMode: nonce.ModeSigned}
+
+	// The signing key is derived from the master key
+	if _, err := loader.ServerConfig(); err == nil {
+		t.Fatalf("Expected error without master key")
+	}
+
+	key, _ := masterkey.Generate()
+	os.Setenv(masterkey.EnvKey, key)
+	defer os.Unsetenv(masterkey.EnvKey)
+
+	serverConfig, err := loader.ServerConfig()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if len(serverConfig.NonceKey) != 32 {
+		t.Fatalf("Expected 32 byte nonce key, got %d", len(serverConfig.NonceKey))
+	}
+
+	// Single use can not be enforced across instances with signed nonces
+	loader.Config.Policy.NonceSingleUse = true
+	if _, err := loader.ServerConfig(); err == nil {
+		t.Fatalf("Expected error for single use with signed nonces")
+	}
+
+	loader.Config.Policy.NonceMode = nonce.ModeLocal
+	if _, err := loader.ServerConfig(); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	loader.Config.Policy.NonceSingleUse = false
+
+	loader.Config.Policy.NonceMode = "shared"
+	if _, err := loader.ServerConfig(); err == nil {
+		t.Fatalf("Expected error for unknown nonce mode")
+	}
+
+}
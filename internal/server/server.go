@@ -21,9 +21,15 @@ import (
 	"time"
 
 	"github.com/jodydadescott/tokens2secrets/internal/app"
+	"github.com/jodydadescott/tokens2secrets/internal/certificate"
+	"github.com/jodydadescott/tokens2secrets/internal/event"
 	"github.com/jodydadescott/tokens2secrets/internal/http"
 	"github.com/jodydadescott/tokens2secrets/internal/keytab"
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
+	"github.com/jodydadescott/tokens2secrets/internal/peer"
 	"github.com/jodydadescott/tokens2secrets/internal/secret"
+	"github.com/jodydadescott/tokens2secrets/internal/sshcert"
+	"github.com/jodydadescott/tokens2secrets/internal/store"
 	"go.uber.org/zap"
 )
 
@@ -34,6 +40,18 @@ type Config struct {
 	SecretSecrets                                       []*secret.Secret
 	KeytabKeytabs                                       []*keytab.Keytab
 	KeytabLifetime                                      time.Duration
+	KeytabKadmin                                        *keytab.Kadmin
+	KeytabWorkers                                       int
+	KeytabJitter                                        time.Duration
+	Events                                              *event.Config
+	Peers                                               *peer.Config
+	Certificate                                         *certificate.Config
+	SSH                                                 *sshcert.Config
+	Store                                               *store.Config
+	MasterKey                                           *masterkey.Key
+	NonceKey                                            []byte
+	NonceSingleUse                                      bool
+	NonceBinding                                        []string
 
 	Listen, TLSCert, TLSKey string
 	HTTPPort, HTTPSPort     int
@@ -60,6 +78,18 @@ func (config *Config) Build() (*Server, error) {
 		SecretSecrets:  config.SecretSecrets,
 		KeytabKeytabs:  config.KeytabKeytabs,
 		KeytabLifetime: config.KeytabLifetime,
+		KeytabKadmin:   config.KeytabKadmin,
+		KeytabWorkers:  config.KeytabWorkers,
+		KeytabJitter:   config.KeytabJitter,
+		Events:         config.Events,
+		Peers:          config.Peers,
+		Certificate:    config.Certificate,
+		SSH:            config.SSH,
+		Store:          config.Store,
+		MasterKey:      config.MasterKey,
+		NonceKey:       config.NonceKey,
+		NonceSingleUse: config.NonceSingleUse,
+		NonceBinding:   config.NonceBinding,
 	}
 
 	app, err := appConfig.Build()
@@ -0,0 +1,76 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshcert
+
+import (
+	"encoding/json"
+
+	"github.com/jinzhu/copier"
+)
+
+const (
+	// TypeUser Certificate that authenticates a user to a host
+	TypeUser = "user"
+
+	// TypeHost Certificate that authenticates a host to a user
+	TypeHost = "host"
+)
+
+// Certificate An issued SSH certificate. Certificate is in the authorized
+// keys format as expected in the -cert.pub file and CA is the public key of
+// the CA in the same format. Exp is the expiration in UNIX seconds.
+type Certificate struct {
+	Certificate string   `json:"certificate,omitempty" yaml:"certificate,omitempty"`
+	CA          string   `json:"ca,omitempty" yaml:"ca,omitempty"`
+	Type        string   `json:"type,omitempty" yaml:"type,omitempty"`
+	KeyID       string   `json:"key_id,omitempty" yaml:"key_id,omitempty"`
+	Principals  []string `json:"principals,omitempty" yaml:"principals,omitempty"`
+	Serial      uint64   `json:"serial,omitempty" yaml:"serial,omitempty"`
+	Exp         int64    `json:"exp,omitempty" yaml:"exp,omitempty"`
+}
+
+// Constraints Principals, lifetime and extensions a certificate is limited
+// to. These are the output of the policy. If Extensions is nil the default
+// extensions of ssh-keygen are used for user certificates.
+type Constraints struct {
+	Principals      []string          `json:"principals,omitempty" yaml:"principals,omitempty"`
+	KeyID           string            `json:"key_id,omitempty" yaml:"key_id,omitempty"`
+	MaxLifetime     int64             `json:"max_lifetime,omitempty" yaml:"max_lifetime,omitempty"`
+	Extensions      map[string]string `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+	CriticalOptions map[string]string `json:"critical_options,omitempty" yaml:"critical_options,omitempty"`
+}
+
+// Request Details of the request. This is provided to the policy.
+type Request struct {
+	Type        string   `json:"type,omitempty" yaml:"type,omitempty"`
+	Principals  []string `json:"principals,omitempty" yaml:"principals,omitempty"`
+	KeyType     string   `json:"key_type,omitempty" yaml:"key_type,omitempty"`
+	Fingerprint string   `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+}
+
+// JSON Return JSON String representation
+func (t *Certificate) JSON() string {
+	j, _ := json.Marshal(t)
+	return string(j)
+}
+
+// Copy return copy of entity
+func (t *Certificate) Copy() *Certificate {
+	clone := &Certificate{}
+	copier.Copy(&clone, &t)
+	return clone
+}
@@ -0,0 +1,33 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshcert
+
+import "errors"
+
+var (
+	// ErrNotConfigured SSH certificate issuance is not configured
+	ErrNotConfigured error = errors.New("SSH certificate issuance is not configured")
+
+	// ErrInvalidPublicKey Public key could not be parsed
+	ErrInvalidPublicKey error = errors.New("Public key is invalid")
+
+	// ErrInvalidType Certificate type is not user or host
+	ErrInvalidType error = errors.New("Certificate type must be user or host")
+
+	// ErrPrincipalNotAllowed Requested principal is not allowed by policy
+	ErrPrincipalNotAllowed error = errors.New("Principal is not allowed")
+)
@@ -0,0 +1,245 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshcert
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultLifetime    = time.Duration(1) * time.Hour
+	defaultMaxLifetime = time.Duration(24) * time.Hour
+
+	// Certificates are back dated to allow for clock skew
+	backdate = time.Duration(1) * time.Minute
+)
+
+// The extensions ssh-keygen adds to user certificates by default
+var defaultUserExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// Config Configuration
+//
+// CAKey: Private key of the CA in OpenSSH or PEM format
+//
+// Lifetime: Lifetime of a certificate if the request does not specify one.
+// Default is one hour
+//
+// MaxLifetime: Maximum lifetime of a certificate regardless of the policy.
+// Default is 24 hours
+type Config struct {
+	CAKey       string
+	Lifetime    time.Duration
+	MaxLifetime time.Duration
+}
+
+// Issuer Issues short lived OpenSSH user and host certificates signed by the
+// CA. A certificate is only issued for the principals allowed by the
+// Constraints from the policy.
+type Issuer struct {
+	signer      ssh.Signer
+	caPublicKey string
+	lifetime    time.Duration
+	maxLifetime time.Duration
+}
+
+// Build Returns a new Issuer
+func (config *Config) Build() (*Issuer, error) {
+
+	zap.L().Debug("Starting")
+
+	if config.CAKey == "" {
+		return nil, fmt.Errorf("CAKey is required")
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(config.CAKey))
+	if err != nil {
+		return nil, fmt.Errorf("CAKey is invalid; %s", err.Error())
+	}
+
+	lifetime := defaultLifetime
+	if config.Lifetime > 0 {
+		lifetime = config.Lifetime
+	}
+
+	maxLifetime := defaultMaxLifetime
+	if config.MaxLifetime > 0 {
+		maxLifetime = config.MaxLifetime
+	}
+
+	if lifetime > maxLifetime {
+		return nil, fmt.Errorf("Lifetime is greater then MaxLifetime")
+	}
+
+	return &Issuer{
+		signer:      signer,
+		caPublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		lifetime:    lifetime,
+		maxLifetime: maxLifetime,
+	}, nil
+}
+
+// ParsePublicKey Returns the public key in authorized keys format and the
+// details of the request that are provided to the policy. If certType is
+// empty a user certificate is requested.
+func ParsePublicKey(input, certType string, principals []string) (ssh.PublicKey, *Request, error) {
+
+	if certType == "" {
+		certType = TypeUser
+	}
+
+	if certType != TypeUser && certType != TypeHost {
+		return nil, nil, ErrInvalidType
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(input))
+	if err != nil {
+		return nil, nil, ErrInvalidPublicKey
+	}
+
+	if _, ok := publicKey.(*ssh.Certificate); ok {
+		return nil, nil, ErrInvalidPublicKey
+	}
+
+	return publicKey, &Request{
+		Type:        certType,
+		Principals:  principals,
+		KeyType:     publicKey.Type(),
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+	}, nil
+}
+
+// Issue Returns a certificate for the public key. If principals were
+// requested each must be allowed by the constraints otherwise the
+// certificate is issued for all of the principals in the constraints. The
+// lifetime is the requested lifetime (or the default) limited by the
+// constraints and the MaxLifetime.
+func (t *Issuer) Issue(publicKey ssh.PublicKey, request *Request, constraints *Constraints, lifetime time.Duration) (*Certificate, error) {
+
+	if t == nil {
+		return nil, ErrNotConfigured
+	}
+
+	if constraints == nil {
+		constraints = &Constraints{}
+	}
+
+	principals := request.Principals
+	if len(principals) == 0 {
+		principals = constraints.Principals
+	}
+
+	if len(principals) == 0 {
+		return nil, fmt.Errorf("%s; no principals", ErrPrincipalNotAllowed)
+	}
+
+	for _, principal := range principals {
+		if !contains(constraints.Principals, principal) {
+			return nil, fmt.Errorf("%s; %s", ErrPrincipalNotAllowed, principal)
+		}
+	}
+
+	if lifetime <= 0 {
+		lifetime = t.lifetime
+	}
+
+	if lifetime > t.maxLifetime {
+		lifetime = t.maxLifetime
+	}
+
+	if constraints.MaxLifetime > 0 && lifetime > time.Duration(constraints.MaxLifetime)*time.Second {
+		lifetime = time.Duration(constraints.MaxLifetime) * time.Second
+	}
+
+	certType := uint32(ssh.UserCert)
+	extensions := defaultUserExtensions
+	if request.Type == TypeHost {
+		certType = ssh.HostCert
+		extensions = nil
+	}
+
+	if constraints.Extensions != nil {
+		extensions = constraints.Extensions
+	}
+
+	keyID := constraints.KeyID
+	if keyID == "" {
+		keyID = strings.Join(principals, ",")
+	}
+
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	serial := binary.BigEndian.Uint64(b)
+
+	now := time.Now()
+	validBefore := now.Add(lifetime)
+
+	cert := &ssh.Certificate{
+		Key:             publicKey,
+		Serial:          serial,
+		CertType:        certType,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-backdate).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: constraints.CriticalOptions,
+			Extensions:      extensions,
+		},
+	}
+
+	err = cert.SignCert(rand.Reader, t.signer)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug(fmt.Sprintf("Issued SSH certificate serial=%d, type=%s, principals=%s, exp=%d", serial, request.Type, principals, validBefore.Unix()))
+
+	return &Certificate{
+		Certificate: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		CA:          t.caPublicKey,
+		Type:        request.Type,
+		KeyID:       keyID,
+		Principals:  principals,
+		Serial:      serial,
+		Exp:         validBefore.Unix(),
+	}, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sshcert
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newKey(t *testing.T) (ssh.PublicKey, string) {
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	return publicKey, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestIssue(t *testing.T) {
+
+	caPublicKey, caKey := newKey(t)
+
+	issuer, err := (&Config{CAKey: caKey}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	userKey, _ := newKey(t)
+
+	publicKey, request, err := ParsePublicKey(string(ssh.MarshalAuthorizedKey(userKey)), "", []string{"bob"})
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if request.Type != TypeUser || request.Fingerprint != ssh.FingerprintSHA256(userKey) {
+		t.Fatalf("Unexpected request %+v", request)
+	}
+
+	result, err := issuer.Issue(publicKey, request, &Constraints{Principals: []string{"bob", "root"}, MaxLifetime: 300}, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(result.Certificate))
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	cert := parsed.(*ssh.Certificate)
+
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(caPublicKey.Marshal())
+		},
+	}
+
+	err = checker.CheckCert("bob", cert)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// Only the requested principal is in the certificate
+	if checker.CheckCert("root", cert) == nil {
+		t.Fatalf("Expected root to not be a valid principal")
+	}
+
+	if _, exist := cert.Extensions["permit-pty"]; !exist {
+		t.Fatalf("Expected default user extensions")
+	}
+
+	if time.Unix(int64(cert.ValidBefore), 0).Sub(time.Now()) > time.Minute*5 {
+		t.Fatalf("Expected lifetime to be limited to 5 minutes")
+	}
+
+}
+
+func TestIssueHost(t *testing.T) {
+
+	_, caKey := newKey(t)
+
+	issuer, err := (&Config{CAKey: caKey}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	hostKey, _ := newKey(t)
+	authorizedKey := string(ssh.MarshalAuthorizedKey(hostKey))
+
+	publicKey, request, err := ParsePublicKey(authorizedKey, TypeHost, nil)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// Without requested principals all allowed principals are used
+	result, err := issuer.Issue(publicKey, request, &Constraints{Principals: []string{"web1.example.com", "web1"}}, 0)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if len(result.Principals) != 2 || result.Type != TypeHost {
+		t.Fatalf("Unexpected certificate %+v", result)
+	}
+
+	_, request, _ = ParsePublicKey(authorizedKey, TypeHost, []string{"web2.example.com"})
+	if _, err := issuer.Issue(publicKey, request, &Constraints{Principals: []string{"web1.example.com"}}, 0); err == nil {
+		t.Fatalf("Expected principal to be denied")
+	}
+
+	if _, _, err := ParsePublicKey(authorizedKey, "other", nil); err != ErrInvalidType {
+		t.Fatalf("Expected ErrInvalidType")
+	}
+
+	if _, _, err := ParsePublicKey("not a key", "", nil); err != ErrInvalidPublicKey {
+		t.Fatalf("Expected ErrInvalidPublicKey")
+	}
+
+}
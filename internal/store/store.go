@@ -0,0 +1,373 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
+	"go.uber.org/zap"
+)
+
+const fileVersion = 1
+
+// contextPrefix Prefix of the context values are sealed with. This separates
+// the values from seeds sealed in the config so that a sealed seed may not be
+// copied into the store.
+const contextPrefix = "store/"
+
+// Config Configuration
+//
+// Path: Path of the file the values are stored in. It is created on the
+// first Put
+//
+// Key: Master key the values are encrypted with
+type Config struct {
+	Path string
+	Key  *masterkey.Key
+}
+
+// Value A stored value. Version starts at 1 and is incremented each time the
+// value is rotated. Updated is the time of the last change in UNIX seconds.
+type Value struct {
+	Name    string
+	Value   string
+	Version int
+	Updated int64
+}
+
+type entry struct {
+	Value   string `json:"value"`
+	Version int    `json:"version"`
+	Updated int64  `json:"updated"`
+}
+
+type storeFile struct {
+	Version int               `json:"version"`
+	Values  map[string]*entry `json:"values"`
+}
+
+// Store File backed store of values encrypted with the master key. Each
+// value is sealed with store/ and its name as context so that values may not
+// be swapped by editing the file. The file is read again when it changes so
+// that values changed with the CLI are served without a restart.
+type Store struct {
+	mutex   sync.Mutex
+	path    string
+	key     *masterkey.Key
+	modTime time.Time
+	values  map[string]*entry
+}
+
+// Build Returns a new Store
+func (config *Config) Build() (*Store, error) {
+
+	zap.L().Debug("Starting")
+
+	if config.Path == "" {
+		return nil, fmt.Errorf("Path is required")
+	}
+
+	if config.Key == nil {
+		return nil, fmt.Errorf("Key is required")
+	}
+
+	t := &Store{
+		path:   config.Path,
+		key:    config.Key,
+		values: make(map[string]*entry),
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// load reads the file if it has changed. A missing file is an empty store.
+func (t *Store) load() error {
+
+	// Must have lock!
+
+	info, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		t.values = make(map[string]*entry)
+		t.modTime = time.Time{}
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(t.modTime) {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return err
+	}
+
+	file := &storeFile{}
+	err = json.Unmarshal(content, file)
+	if err != nil {
+		return fmt.Errorf("Store %s is invalid; %s", t.path, err.Error())
+	}
+
+	if file.Version != fileVersion {
+		return fmt.Errorf("Store %s version %d is not supported", t.path, file.Version)
+	}
+
+	if file.Values == nil {
+		file.Values = make(map[string]*entry)
+	}
+
+	zap.L().Debug(fmt.Sprintf("Loaded %d values from store %s", len(file.Values), t.path))
+
+	t.values = file.Values
+	t.modTime = info.ModTime()
+	return nil
+}
+
+// save writes the values to a temporary file that is then renamed so that a
+// reader never sees a partial file. The values are only kept once they have
+// been written.
+func (t *Store) save(values map[string]*entry) error {
+
+	// Must have lock!
+
+	content, err := json.MarshalIndent(&storeFile{Version: fileVersion, Values: values}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(t.path), ".store")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), t.path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return err
+	}
+
+	t.values = values
+	t.modTime = info.ModTime()
+	return nil
+}
+
+// copyValues returns a copy of the values that may be changed and saved
+func (t *Store) copyValues() map[string]*entry {
+	values := make(map[string]*entry, len(t.values)+1)
+	for name, e := range t.values {
+		values[name] = e
+	}
+	return values
+}
+
+// Get Returns the value with the name
+func (t *Store) Get(name string) (*Value, error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.load()
+	if err != nil {
+		return nil, err
+	}
+
+	e, exist := t.values[name]
+	if !exist {
+		return nil, ErrNotFound
+	}
+
+	plaintext, err := t.key.Open(e.Value, contextPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Value{
+		Name:    name,
+		Value:   plaintext,
+		Version: e.Version,
+		Updated: e.Updated,
+	}, nil
+}
+
+// Names Returns the names of the stored values
+func (t *Store) Names() ([]string, error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range t.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Put Stores a new value. It is an error if the name already exists; use
+// Rotate to change the value.
+func (t *Store) Put(name, value string) (*Value, error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, exist := t.values[name]; exist {
+		return nil, ErrExists
+	}
+
+	return t.set(name, value, 1)
+}
+
+// Rotate Replaces the value with the name and increments the version. If
+// value is empty then a random value is generated.
+func (t *Store) Rotate(name, value string) (*Value, error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.load()
+	if err != nil {
+		return nil, err
+	}
+
+	e, exist := t.values[name]
+	if !exist {
+		return nil, ErrNotFound
+	}
+
+	if value == "" {
+		value, err = generateValue()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t.set(name, value, e.Version+1)
+}
+
+// Delete Removes the value with the name
+func (t *Store) Delete(name string) error {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.load()
+	if err != nil {
+		return err
+	}
+
+	if _, exist := t.values[name]; !exist {
+		return ErrNotFound
+	}
+
+	values := t.copyValues()
+	delete(values, name)
+	return t.save(values)
+}
+
+func (t *Store) set(name, value string, version int) (*Value, error) {
+
+	// Must have lock!
+
+	if name == "" {
+		return nil, fmt.Errorf("Name is required")
+	}
+
+	if value == "" {
+		return nil, fmt.Errorf("Value is required")
+	}
+
+	sealed, err := t.key.Seal(value, contextPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := time.Now().Unix()
+	values := t.copyValues()
+	values[name] = &entry{
+		Value:   sealed,
+		Version: version,
+		Updated: updated,
+	}
+
+	err = t.save(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Value{
+		Name:    name,
+		Value:   value,
+		Version: version,
+		Updated: updated,
+	}, nil
+}
+
+func generateValue() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jodydadescott/tokens2secrets/internal/masterkey"
+)
+
+func TestStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	encoded, _ := masterkey.Generate()
+	key, _ := masterkey.Parse(encoded)
+	path := filepath.Join(dir, "store.json")
+
+	store, err := (&Config{Path: path, Key: key}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if _, err := store.Get("apikey"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound")
+	}
+
+	if _, err := store.Put("apikey", "abc123"); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if _, err := store.Put("apikey", "abc123"); err != ErrExists {
+		t.Fatalf("Expected ErrExists")
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	if len(content) == 0 || strings.Contains(string(content), "abc123") {
+		t.Fatalf("Value must not be stored in plaintext")
+	}
+
+	// A second instance (such as the CLI) sees the same values
+	other, err := (&Config{Path: path, Key: key}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	value, err := other.Get("apikey")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if value.Value != "abc123" || value.Version != 1 {
+		t.Fatalf("Unexpected value %+v", value)
+	}
+
+	rotated, err := other.Rotate("apikey", "")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if rotated.Version != 2 || rotated.Value == "abc123" {
+		t.Fatalf("Unexpected value %+v", rotated)
+	}
+
+	// Change the modification time as the rotation may be within the same
+	// tick of the file system clock as the load by the first instance
+	os.Chtimes(path, store.modTime, store.modTime.Add(1))
+
+	value, err = store.Get("apikey")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if value.Value != rotated.Value {
+		t.Fatalf("Expected rotated value to be loaded")
+	}
+
+	err = store.Delete("apikey")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if _, err := other.Rotate("apikey", "x"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound")
+	}
+
+}
+
+func TestStoreContext(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	encoded, _ := masterkey.Generate()
+	key, _ := masterkey.Parse(encoded)
+	path := filepath.Join(dir, "store.json")
+
+	store, err := (&Config{Path: path, Key: key}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if _, err := store.Put("secret/foo", "abc123"); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// A seed sealed in the config for the secret foo may not be copied into
+	// the store
+	sealed, _ := key.Seal("seed", "secret/foo")
+	store.values["secret/foo"].Value = sealed
+
+	if _, err := store.Get("secret/foo"); err == nil {
+		t.Fatalf("Expected error for value sealed with another context")
+	}
+
+}
+
+func TestStoreSaveFailure(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	encoded, _ := masterkey.Generate()
+	key, _ := masterkey.Parse(encoded)
+
+	store, err := (&Config{Path: filepath.Join(dir, "store.json"), Key: key}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if _, err := store.Put("apikey", "abc123"); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// The directory no longer exists so the file can not be written
+	store.path = filepath.Join(dir, "missing", "store.json")
+
+	if _, err := store.set("other", "abc123", 1); err == nil {
+		t.Fatalf("Expected error for failed save")
+	}
+
+	if _, exist := store.values["other"]; exist {
+		t.Fatalf("Value must not be kept when the save fails")
+	}
+
+	values := store.copyValues()
+	delete(values, "apikey")
+	if err := store.save(values); err == nil {
+		t.Fatalf("Expected error for failed save")
+	}
+
+	if _, exist := store.values["apikey"]; !exist {
+		t.Fatalf("Value must be kept when the save of the delete fails")
+	}
+
+}
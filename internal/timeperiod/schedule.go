@@ -0,0 +1,372 @@
+/*
+Copyright © 2020 Jody Scott <jody@thescottsweb.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timeperiod
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The number of days searched for the next or previous time of a schedule.
+// This is enough for a schedule that only matches on Feb 29.
+const maxSearchDays = 366 * 9
+
+var descriptors = map[string]string{
+	"@hourly":    "0 * * * *",
+	"@daily":     "0 0 * * *",
+	"@midnight":  "0 0 * * *",
+	"@weekly":    "0 0 * * 0",
+	"@monthly":   "0 0 1 * *",
+	"@quarterly": "0 0 1 1,4,7,10 *",
+	"@yearly":    "0 0 1 1 *",
+	"@annually":  "0 0 1 1 *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// schedule Periods defined by a cron expression. A period starts at each time
+// the expression matches and ends at the next. The expression is evaluated in
+// UTC unless a location is given so that every instance computes the same
+// periods regardless of the local time zone of the host.
+type schedule struct {
+	spec     string
+	location *time.Location
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	// If both days and weekdays are restricted a day matches if either
+	// matches. Otherwise both must match. This is the same as cron.
+	anyDay     bool
+	anyWeekday bool
+	// current is the last period returned by from. Nearly every call is for
+	// the current period so it is returned without searching the schedule.
+	mutex   sync.Mutex
+	current *TimePeriod
+}
+
+// Parse Returns the first Period for the provided spec. The spec may be a
+// duration (for example 12h), a calendar interval (@hourly, @daily, @weekly,
+// @monthly, @quarterly or @yearly) or a five field cron expression with
+// minute, hour, day of month, month and day of week (for example "0 2 * * sun"
+// for every Sunday at 02:00). Cron expressions are evaluated in UTC. They may
+// be prefixed with TZ=Location to use another location. Periods from a
+// duration are the same as from NewPeriod.
+func Parse(spec string) (*TimePeriod, error) {
+
+	spec = strings.TrimSpace(spec)
+
+	if spec == "" {
+		return nil, fmt.Errorf("Schedule is empty")
+	}
+
+	if duration, err := time.ParseDuration(spec); err == nil {
+		if duration < time.Second {
+			return nil, fmt.Errorf("Schedule %s is less then one second", spec)
+		}
+		return NewPeriod(duration), nil
+	}
+
+	s, err := parseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TimePeriod{schedule: s}, nil
+}
+
+func parseSchedule(spec string) (*schedule, error) {
+
+	s := &schedule{
+		spec:     spec,
+		location: time.UTC,
+	}
+
+	expression := spec
+
+	if strings.HasPrefix(expression, "TZ=") {
+		fields := strings.SplitN(expression, " ", 2)
+		location, err := time.LoadLocation(strings.TrimPrefix(fields[0], "TZ="))
+		if err != nil {
+			return nil, fmt.Errorf("Schedule %s has invalid location; %s", spec, err.Error())
+		}
+		s.location = location
+		expression = ""
+		if len(fields) > 1 {
+			expression = strings.TrimSpace(fields[1])
+		}
+	}
+
+	if strings.HasPrefix(expression, "@") {
+		value, exist := descriptors[strings.ToLower(expression)]
+		if !exist {
+			return nil, fmt.Errorf("Schedule %s is not a known interval", spec)
+		}
+		expression = value
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Schedule %s is not a duration, interval or cron expression with five fields", spec)
+	}
+
+	var err error
+
+	if err = parseField(fields[0], 0, 59, nil, s.minutes[:]); err != nil {
+		return nil, fmt.Errorf("Schedule %s minute; %s", spec, err.Error())
+	}
+
+	if err = parseField(fields[1], 0, 23, nil, s.hours[:]); err != nil {
+		return nil, fmt.Errorf("Schedule %s hour; %s", spec, err.Error())
+	}
+
+	if err = parseField(fields[2], 1, 31, nil, s.days[:]); err != nil {
+		return nil, fmt.Errorf("Schedule %s day of month; %s", spec, err.Error())
+	}
+
+	if err = parseField(fields[3], 1, 12, monthNames, s.months[:]); err != nil {
+		return nil, fmt.Errorf("Schedule %s month; %s", spec, err.Error())
+	}
+
+	// Sunday may be 0 or 7
+	weekdays := make([]bool, 8)
+	if err = parseField(fields[4], 0, 7, dayNames, weekdays); err != nil {
+		return nil, fmt.Errorf("Schedule %s day of week; %s", spec, err.Error())
+	}
+	copy(s.weekdays[:], weekdays)
+	if weekdays[7] {
+		s.weekdays[0] = true
+	}
+
+	s.anyDay = strings.HasPrefix(fields[2], "*")
+	s.anyWeekday = strings.HasPrefix(fields[4], "*")
+
+	if _, ok := s.next(time.Unix(0, 0)); !ok {
+		return nil, fmt.Errorf("Schedule %s never matches", spec)
+	}
+
+	return s, nil
+}
+
+// parseField sets the values of the field in result. A field is a comma
+// separated list of *, a value or a range of values. Each may be followed by
+// /step.
+func parseField(field string, min, max int, names map[string]int, result []bool) error {
+
+	for _, item := range strings.Split(field, ",") {
+
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return fmt.Errorf("Step %s is invalid", item[i+1:])
+			}
+			item = item[:i]
+		}
+
+		start, end := min, max
+
+		if item != "*" {
+			var err error
+			bounds := strings.SplitN(item, "-", 2)
+			start, err = parseValue(bounds[0], min, max, names)
+			if err != nil {
+				return err
+			}
+			end = start
+			if len(bounds) > 1 {
+				end, err = parseValue(bounds[1], min, max, names)
+				if err != nil {
+					return err
+				}
+			} else if step > 1 {
+				end = max
+			}
+			if end < start {
+				return fmt.Errorf("Range %s is invalid", item)
+			}
+		}
+
+		for i := start; i <= end; i = i + step {
+			result[i] = true
+		}
+	}
+
+	return nil
+}
+
+func parseValue(value string, min, max int, names map[string]int) (int, error) {
+
+	if n, exist := names[strings.ToLower(value)]; exist {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("Value %s is invalid; must be between %d and %d", value, min, max)
+	}
+
+	return n, nil
+}
+
+// matchDay true if the schedule matches the date
+func (t *schedule) matchDay(date time.Time) bool {
+
+	if !t.months[date.Month()] {
+		return false
+	}
+
+	if t.anyDay || t.anyWeekday {
+		return t.days[date.Day()] && t.weekdays[date.Weekday()]
+	}
+
+	return t.days[date.Day()] || t.weekdays[date.Weekday()]
+}
+
+// at returns the time on the date at the hour and minute in the location of
+// the schedule. Times that do not exist because of a daylight saving
+// transition are skipped.
+func (t *schedule) at(date time.Time, hour, minute int) (time.Time, bool) {
+	result := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, t.location)
+	if result.Hour() != hour || result.Minute() != minute {
+		return result, false
+	}
+	return result, true
+}
+
+// next returns the first time after input that matches the schedule
+func (t *schedule) next(input time.Time) (time.Time, bool) {
+
+	start := input.Truncate(time.Minute).Add(time.Minute).In(t.location)
+
+	// Days are stepped in UTC as only the date is used
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < maxSearchDays; i++ {
+
+		date := day.AddDate(0, 0, i)
+		if !t.matchDay(date) {
+			continue
+		}
+
+		for hour := 0; hour < 24; hour++ {
+			if !t.hours[hour] {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if !t.minutes[minute] {
+					continue
+				}
+				result, ok := t.at(date, hour, minute)
+				if ok && !result.Before(start) {
+					return result, true
+				}
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// prev returns the last time at or before input that matches the schedule
+func (t *schedule) prev(input time.Time) (time.Time, bool) {
+
+	end := input.Truncate(time.Minute).In(t.location)
+
+	day := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < maxSearchDays; i++ {
+
+		date := day.AddDate(0, 0, -i)
+		if !t.matchDay(date) {
+			continue
+		}
+
+		for hour := 23; hour >= 0; hour-- {
+			if !t.hours[hour] {
+				continue
+			}
+			for minute := 59; minute >= 0; minute-- {
+				if !t.minutes[minute] {
+					continue
+				}
+				result, ok := t.at(date, hour, minute)
+				if ok && !result.After(end) {
+					return result, true
+				}
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// period returns the period that starts at start
+func (t *schedule) period(start time.Time) *TimePeriod {
+
+	end, ok := t.next(start)
+	if !ok {
+		end = start
+	}
+
+	return &TimePeriod{
+		Duration: end.Sub(start),
+		Epoch:    start.Unix(),
+		schedule: t,
+	}
+}
+
+// from returns the period that contains input
+func (t *schedule) from(input time.Time) *TimePeriod {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.current != nil && !input.Before(t.current.Time()) && input.Before(t.current.End()) {
+		period := *t.current
+		return &period
+	}
+
+	start, ok := t.prev(input)
+	if !ok || start.Unix() < 0 {
+		start = time.Unix(0, 0)
+	}
+
+	period := t.period(start)
+	current := *period
+	t.current = &current
+	return period
+}
+
+// shift returns the period with the start moved by offset
+func (t *TimePeriod) shift(offset time.Duration) *TimePeriod {
+	t.Epoch = t.Epoch + int64(offset.Seconds())
+	t.Offset = offset
+	return t
+}
@@ -16,7 +16,12 @@ limitations under the License.
 
 package timeperiod
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
 
 // Example
 // epochPeriod := NewPeriod(time.Duration(2) * time.Hour)
@@ -24,12 +29,70 @@ import "time"
 // nowPeriod := epochPeriod.From(now)
 // nextPeriod := nowPeriod.Next()
 // prePeriod := nowPeriod.Prev()
+//
+// Periods may also follow a schedule with Parse
+// epochPeriod, err := Parse("0 2 * * sun")
+//
+// The boundaries of the periods may be shifted with an offset
+// epochPeriod = epochPeriod.WithOffset(epochPeriod.HashOffset("name"))
 
 // TimePeriod Period of time defined by duration and epoch where epoch is the
-// start of the TimePeriod
+// start of the TimePeriod. If the TimePeriod is from a schedule the periods
+// are not all the same length and Duration is the length of this period.
+// Offset shifts the start of every period so that items with the same
+// duration or schedule do not all start a new period at the same time.
 type TimePeriod struct {
 	Duration time.Duration
 	Epoch    int64
+	Offset   time.Duration
+	schedule *schedule
+}
+
+// Config How the periods of an item such as a keytab or secret are set
+//
+// Lifetime: Length of each period. Used if Schedule is not set
+//
+// Schedule: Duration, calendar interval or cron expression. See Parse
+//
+// Offset: Shifts the start of every period
+//
+// Stagger: If Offset is not set the offset is derived from a hash of the name
+// of the item so that items with the same lifetime do not all start a new
+// period at the same time
+type Config struct {
+	Lifetime time.Duration
+	Schedule string
+	Offset   time.Duration
+	Stagger  bool
+}
+
+// Build Returns the first Period for the item with name
+func (config *Config) Build(name string) (*TimePeriod, error) {
+
+	var period *TimePeriod
+
+	if config.Schedule != "" {
+		var err error
+		period, err = Parse(config.Schedule)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if config.Lifetime < time.Second {
+			return nil, fmt.Errorf("Lifetime %s is less then one second", config.Lifetime)
+		}
+		period = NewPeriod(config.Lifetime)
+	}
+
+	if config.Offset != 0 {
+		return period.WithOffset(config.Offset), nil
+	}
+
+	if config.Stagger {
+		return period.WithOffset(period.HashOffset(name)), nil
+	}
+
+	return period, nil
 }
 
 // NewPeriod Returns first Period from epoch with provided duration
@@ -42,14 +105,21 @@ func NewPeriod(duration time.Duration) *TimePeriod {
 
 // Next Returns first Period after current
 func (t *TimePeriod) Next() *TimePeriod {
+	if t.schedule != nil {
+		return t.schedule.period(t.End().Add(-t.Offset)).shift(t.Offset)
+	}
 	return &TimePeriod{
 		Duration: t.Duration,
 		Epoch:    t.Epoch + int64(t.Duration.Seconds()),
+		Offset:   t.Offset,
 	}
 }
 
 // Prev Returns First Period before current
 func (t *TimePeriod) Prev() *TimePeriod {
+	if t.schedule != nil {
+		return t.schedule.from(t.Time().Add(-t.Offset - time.Minute)).shift(t.Offset)
+	}
 	// Once we hit 0 or Jan 1 1970 we can not go back anymore so we just keep
 	// returning Jan 1 1970
 	epoch := t.Epoch - int64(t.Duration.Seconds())
@@ -59,6 +129,7 @@ func (t *TimePeriod) Prev() *TimePeriod {
 	return &TimePeriod{
 		Duration: t.Duration,
 		Epoch:    epoch,
+		Offset:   t.Offset,
 	}
 }
 
@@ -67,18 +138,101 @@ func (t *TimePeriod) Time() time.Time {
 	return time.Unix(t.Epoch, 0)
 }
 
+// End Returns the time the period ends which is the top of the next period
+func (t *TimePeriod) End() time.Time {
+	return time.Unix(t.Epoch+int64(t.Duration.Seconds()), 0)
+}
+
+// Index Returns the number of the period. For a duration this is the number
+// of periods since Jan 1 1970 and increases by one each period. The periods
+// of a schedule are not the same length so the index is the epoch which only
+// identifies the period and does not increase by one. Either way every
+// instance computes the same index for the same period.
+func (t *TimePeriod) Index() int64 {
+	if t.schedule != nil {
+		return t.Epoch
+	}
+	return (t.Epoch - int64(t.Offset.Seconds())) / int64(t.Duration.Seconds())
+}
+
+// IsSchedule Returns true if the periods are from a schedule and not all the
+// same length
+func (t *TimePeriod) IsSchedule() bool {
+	return t.schedule != nil
+}
+
+// WithOffset Returns the Period with the start of every period shifted by
+// offset. An offset of a duration or more is the same as the remainder.
+func (t *TimePeriod) WithOffset(offset time.Duration) *TimePeriod {
+
+	offset = offset.Truncate(time.Second)
+
+	if t.schedule == nil && t.Duration >= time.Second {
+		offset = offset % t.Duration.Truncate(time.Second)
+		if offset < 0 {
+			offset = offset + t.Duration.Truncate(time.Second)
+		}
+	}
+
+	return &TimePeriod{
+		Duration: t.Duration,
+		Epoch:    t.Epoch - int64(t.Offset.Seconds()) + int64(offset.Seconds()),
+		Offset:   offset,
+		schedule: t.schedule,
+	}
+}
+
+// HashOffset Returns an offset within the length of a period derived from a
+// hash of the name. Every instance derives the same offset for the same name
+// while different names are spread across the period. The offset is in whole
+// minutes unless the period is less then two minutes.
+func (t *TimePeriod) HashOffset(name string) time.Duration {
+
+	length := t.Duration
+	if t.schedule != nil {
+		// The first period may be cut short by Jan 1 1970 so the one after is used
+		length = t.schedule.from(time.Unix(0, 0)).Next().Duration
+	}
+
+	unit := time.Minute
+	if length < 2*time.Minute {
+		unit = time.Second
+	}
+
+	count := int64(length / unit)
+	if count <= 0 {
+		return 0
+	}
+
+	hash := sha256.Sum256([]byte(name))
+	return time.Duration(binary.BigEndian.Uint64(hash[:8])%uint64(count)) * unit
+}
+
+// String Returns the spec of the schedule or the duration
+func (t *TimePeriod) String() string {
+	if t.schedule != nil {
+		return t.schedule.spec
+	}
+	return t.Duration.String()
+}
+
 // From Returns Period period that contains provided time
 func (t *TimePeriod) From(input time.Time) *TimePeriod {
+	if t.schedule != nil {
+		return t.schedule.from(input.Add(-t.Offset)).shift(t.Offset)
+	}
 	// Determine number of seconds time is from top of current period and subtract
-	// them hence top of period
-	epoch := input.Unix()
+	// them hence top of period. The offset is removed first and then added back.
+	offset := int64(t.Offset.Seconds())
+	epoch := input.Unix() - offset
 	s := int64(t.Duration.Seconds())
 	_, remainderSeconds := epoch/s, epoch%s
-	epoch = epoch - remainderSeconds
+	epoch = epoch - remainderSeconds + offset
 
 	return &TimePeriod{
 		Duration: t.Duration,
 		Epoch:    epoch,
+		Offset:   t.Offset,
 	}
 }
 
@@ -116,3 +116,250 @@ func Test4(t *testing.T) {
 	}
 
 }
+
+func TestSchedule(t *testing.T) {
+
+	// Every Sunday at 02:00 UTC
+	epochPeriod, err := Parse("0 2 * * sun")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	now := time.Date(2022, 1, 19, 18, 13, 0, 0, time.UTC)
+	nowPeriod := epochPeriod.From(now)
+	nextPeriod := nowPeriod.Next()
+	prePeriod := nowPeriod.Prev()
+
+	if !nowPeriod.Time().Equal(time.Date(2022, 1, 16, 2, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Period now fail; got %s", nowPeriod.Time().UTC())
+	}
+
+	if !nowPeriod.End().Equal(time.Date(2022, 1, 23, 2, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Period end fail; got %s", nowPeriod.End().UTC())
+	}
+
+	if !nextPeriod.Time().Equal(nowPeriod.End()) {
+		t.Fatalf("Period next fail; got %s", nextPeriod.Time().UTC())
+	}
+
+	if !prePeriod.Time().Equal(time.Date(2022, 1, 9, 2, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Period prev fail; got %s", prePeriod.Time().UTC())
+	}
+
+	if nowPeriod.HalfLife(time.Date(2022, 1, 19, 14, 0, 0, 0, time.UTC)) {
+		t.Fatalf("not expected")
+	}
+
+	if !nowPeriod.HalfLife(time.Date(2022, 1, 19, 14, 1, 0, 0, time.UTC)) {
+		t.Fatalf("not expected")
+	}
+
+	// The start of a period is in that period
+	if epochPeriod.From(nowPeriod.Time()).Epoch != nowPeriod.Epoch {
+		t.Fatalf("Period from start fail")
+	}
+
+}
+
+func TestScheduleCurrent(t *testing.T) {
+
+	epochPeriod, err := Parse("0 2 * * sun")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// The current period is kept so each time must give the same period as
+	// a schedule that has not seen any other time
+	times := []time.Time{
+		time.Date(2022, 1, 19, 18, 13, 0, 0, time.UTC),
+		time.Date(2022, 1, 16, 2, 0, 0, 0, time.UTC),
+		time.Date(2022, 1, 23, 1, 59, 59, 0, time.UTC),
+		time.Date(2022, 1, 23, 2, 0, 0, 0, time.UTC),
+		time.Date(2022, 1, 16, 1, 59, 59, 0, time.UTC),
+		time.Date(2022, 1, 19, 18, 13, 0, 0, time.UTC),
+	}
+
+	for _, now := range times {
+		fresh, _ := Parse("0 2 * * sun")
+		expected := fresh.WithOffset(time.Hour).From(now)
+		period := epochPeriod.WithOffset(time.Hour).From(now)
+		if period.Epoch != expected.Epoch || period.Duration != expected.Duration || period.Offset != expected.Offset {
+			t.Fatalf("Period from %s fail; got %s", now, period.Time().UTC())
+		}
+		// The period returned may be changed without changing the schedule
+		period.Epoch = 0
+	}
+
+}
+
+func TestScheduleCalendar(t *testing.T) {
+
+	epochPeriod, err := Parse("@monthly")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	nowPeriod := epochPeriod.From(time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC))
+
+	if !nowPeriod.Time().Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Period now fail; got %s", nowPeriod.Time().UTC())
+	}
+
+	if nowPeriod.Duration != time.Duration(29*24)*time.Hour {
+		t.Fatalf("Expected 29 day period, got %s", nowPeriod.Duration)
+	}
+
+	if nowPeriod.Next().Duration != time.Duration(31*24)*time.Hour {
+		t.Fatalf("Expected 31 day period, got %s", nowPeriod.Next().Duration)
+	}
+
+	if nowPeriod.Index() == nowPeriod.Next().Index() {
+		t.Fatalf("Expected different index for each period")
+	}
+
+	// The location changes when the period starts
+	epochPeriod, err = Parse("TZ=America/New_York @daily")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	nowPeriod = epochPeriod.From(time.Date(2024, 2, 10, 3, 0, 0, 0, time.UTC))
+
+	if !nowPeriod.Time().Equal(time.Date(2024, 2, 9, 5, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Period now fail; got %s", nowPeriod.Time().UTC())
+	}
+
+}
+
+func TestParse(t *testing.T) {
+
+	period, err := Parse("2h")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	now := time.Date(2022, 1, 19, 18, 13, 0, 0, time.UTC)
+	if *period.From(now) != *NewPeriod(time.Duration(2) * time.Hour).From(now) {
+		t.Fatalf("Expected duration schedule to be the same as NewPeriod")
+	}
+
+	if period.From(now).Index() != period.From(now).Epoch/7200 {
+		t.Fatalf("Unexpected index %d", period.From(now).Index())
+	}
+
+	for _, spec := range []string{"", "1ms", "@never", "* * * *", "60 * * * *", "0 0 30 2 *", "0 0 * * mon-sun/0", "TZ=Nowhere/Nothing @daily"} {
+		if _, err := Parse(spec); err == nil {
+			t.Fatalf("Expected error for %s", spec)
+		}
+	}
+
+}
+
+func TestOffset(t *testing.T) {
+
+	epochPeriod := NewPeriod(time.Duration(2) * time.Hour).WithOffset(time.Duration(25) * time.Minute)
+	now := time.Date(2022, 1, 19, 18, 13, 0, 0, time.UTC)
+	nowPeriod := epochPeriod.From(now)
+
+	if !nowPeriod.Time().Equal(time.Date(2022, 1, 19, 16, 25, 0, 0, time.UTC)) {
+		t.Fatalf("Period now fail; got %s", nowPeriod.Time().UTC())
+	}
+
+	if !nowPeriod.Next().Time().Equal(time.Date(2022, 1, 19, 18, 25, 0, 0, time.UTC)) {
+		t.Fatalf("Period next fail; got %s", nowPeriod.Next().Time().UTC())
+	}
+
+	if !nowPeriod.Prev().Time().Equal(time.Date(2022, 1, 19, 14, 25, 0, 0, time.UTC)) {
+		t.Fatalf("Period prev fail; got %s", nowPeriod.Prev().Time().UTC())
+	}
+
+	if nowPeriod.Next().Index() != nowPeriod.Index()+1 {
+		t.Fatalf("Expected index to increment by one")
+	}
+
+	// An offset of more then the duration is the same as the remainder
+	if *NewPeriod(time.Duration(2) * time.Hour).WithOffset(time.Duration(145) * time.Minute).From(now) != *nowPeriod {
+		t.Fatalf("Expected offset to wrap")
+	}
+
+	schedulePeriod, err := Parse("0 2 * * sun")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	nowPeriod = schedulePeriod.WithOffset(time.Duration(90) * time.Minute).From(time.Date(2022, 1, 16, 3, 0, 0, 0, time.UTC))
+
+	if !nowPeriod.Time().Equal(time.Date(2022, 1, 9, 3, 30, 0, 0, time.UTC)) {
+		t.Fatalf("Schedule now fail; got %s", nowPeriod.Time().UTC())
+	}
+
+	if !nowPeriod.Next().Time().Equal(time.Date(2022, 1, 16, 3, 30, 0, 0, time.UTC)) {
+		t.Fatalf("Schedule next fail; got %s", nowPeriod.Next().Time().UTC())
+	}
+
+	if !nowPeriod.Prev().Time().Equal(time.Date(2022, 1, 2, 3, 30, 0, 0, time.UTC)) {
+		t.Fatalf("Schedule prev fail; got %s", nowPeriod.Prev().Time().UTC())
+	}
+
+}
+
+func TestHashOffset(t *testing.T) {
+
+	epochPeriod := NewPeriod(time.Duration(12) * time.Hour)
+
+	offset := epochPeriod.HashOffset("secret1")
+
+	if offset != epochPeriod.HashOffset("secret1") {
+		t.Fatalf("Expected the same offset for the same name")
+	}
+
+	if offset < 0 || offset >= epochPeriod.Duration || offset%time.Minute != 0 {
+		t.Fatalf("Unexpected offset %s", offset)
+	}
+
+	if offset == epochPeriod.HashOffset("secret2") {
+		t.Fatalf("Expected a different offset for a different name")
+	}
+
+	schedulePeriod, err := Parse("@weekly")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	offset = schedulePeriod.HashOffset("secret1")
+	if offset < 0 || offset >= time.Duration(7*24)*time.Hour {
+		t.Fatalf("Unexpected offset %s", offset)
+	}
+
+}
+
+func TestConfig(t *testing.T) {
+
+	period, err := (&Config{Lifetime: time.Hour, Offset: 15 * time.Minute, Stagger: true}).Build("name")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// An explicit offset is used in place of the hash
+	if period.Duration != time.Hour || period.Offset != 15*time.Minute {
+		t.Fatalf("Unexpected period %s with offset %s", period, period.Offset)
+	}
+
+	period, err = (&Config{Lifetime: time.Hour, Schedule: "@weekly", Stagger: true}).Build("name")
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if !period.IsSchedule() || period.Offset != period.HashOffset("name") {
+		t.Fatalf("Expected staggered schedule")
+	}
+
+	if _, err := (&Config{Schedule: "@never"}).Build("name"); err == nil {
+		t.Fatalf("Expected error for invalid schedule")
+	}
+
+	if _, err := (&Config{}).Build("name"); err == nil {
+		t.Fatalf("Expected error without lifetime or schedule")
+	}
+
+}
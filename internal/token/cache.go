@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
 	"github.com/jodydadescott/tokens2secrets/internal/publickey"
 	"go.uber.org/zap"
 )
@@ -38,8 +39,10 @@ const (
 // PublicKeyLifetime is the lifetime of Public Keys as they do not have a defined life
 // NonceLifetime is the lifetime of a Nonce
 // RequestTimeout is the request timeout for the HTTP client
+// Clock is the source of time. Default is the system clock
 type Config struct {
 	CacheRefresh time.Duration
+	Clock        clock.Clock
 }
 
 // Cache Parses and verifies tokens by fetching public keys from the token issuer and caching
@@ -52,7 +55,8 @@ type Cache struct {
 	tokenMapMutex       sync.RWMutex
 	tokenMap            map[string]*Token
 	closed              chan struct{}
-	ticker              *time.Ticker
+	ticker              clock.Ticker
+	clock               clock.Clock
 	wg                  sync.WaitGroup
 	seededRand          *rand.Rand
 	permitPublicKeyHTTP bool
@@ -86,10 +90,16 @@ func (config *Config) Build(publicKeyCache publickey.Cache) (*Cache, error) {
 		return nil, fmt.Errorf("publicKeyCache is nil")
 	}
 
+	timeSource := clock.Real()
+	if config.Clock != nil {
+		timeSource = config.Clock
+	}
+
 	t := &Cache{
 		tokenMap:       make(map[string]*Token),
 		closed:         make(chan struct{}),
-		ticker:         time.NewTicker(cacheRefresh),
+		ticker:         timeSource.NewTicker(cacheRefresh),
+		clock:          timeSource,
 		wg:             sync.WaitGroup{},
 		publicKeyCache: publicKeyCache,
 	}
@@ -99,9 +109,10 @@ func (config *Config) Build(publicKeyCache publickey.Cache) (*Cache, error) {
 		for {
 			select {
 			case <-t.closed:
+				t.ticker.Stop()
 				t.wg.Done()
 				return
-			case <-t.ticker.C:
+			case <-t.ticker.C():
 				zap.L().Debug("Processing cache start")
 				t.processTokenCache()
 				zap.L().Debug("Processing cache completed")
@@ -126,7 +137,7 @@ func (t *Cache) ParseToken(tokenString string) (*Token, error) {
 	if token != nil {
 		zap.L().Debug(fmt.Sprintf("Token %s found in cache", tokenString))
 
-		if token.Exp > time.Now().Unix() {
+		if token.IsExpired(t.clock.Now()) {
 			zap.L().Debug(fmt.Sprintf("Token %s is expired", tokenString))
 			return nil, ErrExpired
 		}
@@ -218,7 +229,7 @@ func (t *Cache) ParseToken(tokenString string) (*Token, error) {
 		}
 	}
 
-	if time.Now().Unix() > token.Exp {
+	if token.IsExpired(t.clock.Now()) {
 		zap.L().Debug(fmt.Sprintf("Token %s is expired", tokenString))
 		return nil, ErrExpired
 	}
@@ -238,7 +249,7 @@ func (t *Cache) processTokenCache() {
 
 	for key, e := range t.tokenMap {
 
-		if time.Now().Unix() > e.Exp {
+		if e.IsExpired(t.clock.Now()) {
 			removes = append(removes, key)
 			zap.L().Info(fmt.Sprintf("Ejecting->%s", e.JSON()))
 		} else {
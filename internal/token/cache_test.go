@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/jodydadescott/tokens2secrets/internal/clock"
 	"github.com/jodydadescott/tokens2secrets/internal/publickey"
 )
 
@@ -151,6 +152,56 @@ func runTest1() error {
 	return nil
 }
 
+func TestExpiry(t *testing.T) {
+
+	fake := clock.NewFake(time.Now())
+	now := fake.Now().Unix()
+
+	privateKey, publicKey, err := generateKeypair("https://issuer-a", "x", now+3600)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	testKeyCache := publickey.Dummy()
+	testKeyCache.PutKey(publicKey)
+
+	tokenCache, err := (&Config{CacheRefresh: time.Minute, Clock: fake}).Build(testKeyCache)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+	defer tokenCache.Shutdown()
+
+	tokenString, err := newToken("https://issuer-a", "x", now+600, privateKey)
+	if err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	if _, err := tokenCache.ParseToken(tokenString); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	// The second parse is from the cache and the token is still valid
+	if tokenCache.mapGetToken(tokenString) == nil {
+		t.Fatalf("Expected token to be cached")
+	}
+	if _, err := tokenCache.ParseToken(tokenString); err != nil {
+		t.Fatalf("Unexpected err %s", err)
+	}
+
+	fake.Advance(601 * time.Second)
+
+	// The token is still cached but now expired
+	if _, err := tokenCache.ParseToken(tokenString); err != ErrExpired {
+		t.Fatalf("Expected ErrExpired, got %v", err)
+	}
+
+	tokenCache.processTokenCache()
+	if tokenCache.mapGetToken(tokenString) != nil {
+		t.Fatalf("Expected expired token to be evicted")
+	}
+
+}
+
 func newToken(iss, kid string, exp int64, key *ecdsa.PrivateKey) (string, error) {
 
 	claims := &jwt.StandardClaims{
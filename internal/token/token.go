@@ -49,9 +49,9 @@ func (t *Token) Copy() *Token {
 	return c
 }
 
-// IsExpired returns true if token is expired
-func (t *Token) IsExpired() bool {
-	if t.Exp < time.Now().Unix() {
+// IsExpired returns true if token is expired at now
+func (t *Token) IsExpired(now time.Time) bool {
+	if t.Exp < now.Unix() {
 		return true
 	}
 	return false